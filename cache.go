@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Cache-Control rules used when nothing else is configured
+// Personal data may only be kept by the client that was allowed to read it
+const defaultCacheControl = "/=public, max-age=60; " +
+	"/customers=private, no-cache; /employees=private, no-cache"
+
+// Paths whose handlers validate requests themselves
+// The favicon is served by http.ServeFile, which compares it to its file
+var uncachedPaths = []string{"/favicon.ico"}
+
+// cacheRule sets the Cache-Control header of responses to a path and the
+// paths below it
+type cacheRule struct {
+	prefix  string
+	control string
+}
+
+// Function to parse a cache_control value such as
+// "/=public, max-age=60; /customers=private, no-cache"
+// An empty value after = sends no Cache-Control header for that path
+func parseCacheRules(value string) ([]cacheRule, error) {
+	var rules []cacheRule
+	for _, part := range strings.Split(value, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		eq := strings.Index(part, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("rule %q is not in the form path=directives",
+				part)
+		}
+		prefix := strings.TrimSpace(part[:eq])
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("path %q does not begin with /", prefix)
+		}
+		rules = append(rules, cacheRule{
+			prefix:  "/" + strings.Trim(prefix, "/"),
+			control: strings.TrimSpace(part[eq+1:]),
+		})
+	}
+	return rules, nil
+}
+
+// Function to get the Cache-Control header for a path from the rule with
+// the longest matching prefix
+func cacheControl(rules []cacheRule, path string) string {
+	best := -1
+	for i, rule := range rules {
+		under := rule.prefix == "/" || path == rule.prefix ||
+			strings.HasPrefix(path, rule.prefix+"/")
+		if under && (best < 0 || len(rule.prefix) > len(rules[best].prefix)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	return rules[best].control
+}
+
+// Function to compute the entity tag and modification time of the response
+// to a GET request
+// The tag covers everything the response depends on: the database version,
+// the request and the headers used to negotiate it. The cursor secret is
+// included as cursors issued by another secret differ
+func (s *Server) validators(r *http.Request) (string, time.Time) {
+	v := s.store.Version()
+	h := sha256.New()
+	h.Write(s.cursorSecret)
+	fmt.Fprintf(h, "\n%d\n%d\n%s\n%s\n%s\n%s", v.Writes,
+		v.Modified.UnixNano(), r.URL.Path, r.URL.Query().Encode(),
+		r.Header.Get("Accept"), r.Header.Get("Authorization"))
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`, v.Modified
+}
+
+// Function to check whether an If-None-Match header matches an entity tag
+// GET requests use the weak comparison, so a W/ prefix is ignored
+func etagMatches(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Function to check whether the client already has the response
+// If-Modified-Since is ignored when If-None-Match is given
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		return etagMatches(header, etag)
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modified.IsZero() &&
+		!modified.Truncate(time.Second).After(since)
+}
+
+// cacheWriter adds the caching headers to a successful response
+// When the request's conditions show the client already has the response,
+// its 200 status is sent as 304 Not Modified without the body. Handlers
+// that set Cache-Control themselves opt out, so no validators are added
+type cacheWriter struct {
+	http.ResponseWriter
+	etag        string
+	modified    time.Time
+	control     string
+	notModified bool
+	wroteHeader bool
+	skipBody    bool
+}
+
+// Function to set the validators and Cache-Control header of a response
+func (cw *cacheWriter) setHeaders() {
+	h := cw.Header()
+	h.Set("ETag", cw.etag)
+	if !cw.modified.IsZero() {
+		h.Set("Last-Modified", cw.modified.UTC().Format(http.TimeFormat))
+	}
+	if cw.control != "" {
+		h.Set("Cache-Control", cw.control)
+	}
+}
+
+// WriteHeader implements http.ResponseWriter
+// Only 200 responses are cached, errors always reach the client
+func (cw *cacheWriter) WriteHeader(status int) {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		if status == http.StatusOK && cw.Header().Get("Cache-Control") == "" {
+			cw.setHeaders()
+			if cw.notModified {
+				status, cw.skipBody = http.StatusNotModified, true
+				cw.Header().Del("Content-Type")
+				cw.Header().Del("Content-Length")
+			}
+		}
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (cw *cacheWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.skipBody {
+		return len(p), nil
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush implements http.Flusher
+func (cw *cacheWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Function to answer a request with 304 Not Modified before building a
+// response the client already has, reporting whether it did
+// Handlers call it once the request is known to be valid, to skip costly
+// queries. Other handlers build the response, which is then not sent
+func writeNotModified(w http.ResponseWriter) bool {
+	cw, ok := w.(*cacheWriter)
+	if !ok || !cw.notModified || cw.wroteHeader {
+		return false
+	}
+	cw.WriteHeader(http.StatusOK)
+	return true
+}
+
+// Middleware that lets clients and shared caches keep GET responses until
+// the database changes
+// Responses get an ETag, Last-Modified and the configured Cache-Control
+// header, and a conditional request the client already has the response
+// for is answered with 304 Not Modified instead of 200
+func (s *Server) withCaching(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet ||
+			containsString(uncachedPaths, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		etag, modified := s.validators(r)
+		next.ServeHTTP(&cacheWriter{ResponseWriter: w, etag: etag,
+			modified: modified, control: cacheControl(s.cacheRules, r.URL.Path),
+			notModified: notModified(r, etag, modified)}, r)
+	})
+}
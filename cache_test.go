@@ -0,0 +1,211 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheControl(t *testing.T) {
+	rules, err := parseCacheRules(defaultCacheControl +
+		"; /reports/=public, max-age=300; /playlists=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "public, max-age=60"},
+		{"/tracks", "public, max-age=60"},
+		{"/customers", "private, no-cache"},
+		{"/customers/1/invoices", "private, no-cache"},
+		{"/customersx", "public, max-age=60"},
+		{"/reports/top-tracks", "public, max-age=300"},
+		{"/playlists/1", ""},
+	}
+	for _, test := range tests {
+		if got := cacheControl(rules, test.path); got != test.want {
+			t.Errorf("%s has wrong Cache-Control: \n\ngot\n\n%q\n\nwant\n\n%q",
+				test.path, got, test.want)
+		}
+	}
+
+	for _, value := range []string{"public", "tracks=no-store"} {
+		if _, err := parseCacheRules(value); err == nil {
+			t.Errorf("%q: expected error, got nil", value)
+		}
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	handler := testServer.routes()
+	rec := getWithHeaders(handler, "/genres", nil)
+	etag, modified := rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(etag, `"`) ||
+		modified == "" {
+		t.Fatalf("/genres returned %v with ETag %q and Last-Modified %q",
+			rec.Code, etag, modified)
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=60" {
+		t.Errorf("/genres has wrong Cache-Control %q", got)
+	}
+	if again := getWithHeaders(handler, "/genres", nil); again.Header().Get(
+		"ETag") != etag {
+		t.Errorf("/genres ETag is not stable")
+	}
+
+	earlier := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).Format(
+		http.TimeFormat)
+	later := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	tests := []struct {
+		target  string
+		headers map[string]string
+		want    int
+	}{
+		{"/genres", map[string]string{"If-None-Match": etag},
+			http.StatusNotModified},
+		{"/genres", map[string]string{"If-None-Match": `"x", W/` + etag},
+			http.StatusNotModified},
+		{"/genres", map[string]string{"If-None-Match": "*"},
+			http.StatusNotModified},
+		{"/genres", map[string]string{"If-None-Match": `"x"`}, http.StatusOK},
+		{"/genres", map[string]string{"If-Modified-Since": modified},
+			http.StatusNotModified},
+		{"/genres", map[string]string{"If-Modified-Since": earlier},
+			http.StatusOK},
+		{"/genres", map[string]string{"If-None-Match": `"x"`,
+			"If-Modified-Since": modified}, http.StatusOK},
+		{"/genres?pretty=true", map[string]string{"If-None-Match": etag},
+			http.StatusOK},
+		{"/tracks/1", map[string]string{"If-None-Match": etag},
+			http.StatusOK},
+		{"/tracks/1", map[string]string{"If-None-Match": etag,
+			"Accept": "text/csv"}, http.StatusOK},
+		// Only a response that would be 200 becomes 304
+		{"/nope", map[string]string{"If-None-Match": "*"},
+			http.StatusNotFound},
+		{"/?search=", map[string]string{"If-Modified-Since": later},
+			http.StatusBadRequest},
+		{"/tracks/99999", map[string]string{"If-Modified-Since": later},
+			http.StatusNotFound},
+	}
+	for _, test := range tests {
+		rec := getWithHeaders(handler, test.target, test.headers)
+		if rec.Code != test.want {
+			t.Errorf("%s %v returned wrong status code: "+
+				"\n\ngot\n\n%v\n\nwant\n\n%v",
+				test.target, test.headers, rec.Code, test.want)
+		}
+		if rec.Code == http.StatusNotModified &&
+			(rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag) {
+			t.Errorf("%s %v returned 304 with ETag %q and body %q",
+				test.target, test.headers, rec.Header().Get("ETag"),
+				rec.Body.String())
+		}
+	}
+
+	// A 304 keeps the Vary headers of the full response
+	varies := []struct {
+		target string
+		vary   string
+	}{
+		{"/tracks?limit=5", "Accept"},
+		{"/customers", "Authorization"},
+	}
+	for _, test := range varies {
+		rec := getWithHeaders(handler, test.target, nil)
+		rec = getWithHeaders(handler, test.target,
+			map[string]string{"If-None-Match": rec.Header().Get("ETag")})
+		if rec.Code != http.StatusNotModified ||
+			!strings.Contains(rec.Header().Get("Vary"), test.vary) {
+			t.Errorf("%s returned %v with headers %v", test.target, rec.Code,
+				rec.Header())
+		}
+	}
+
+	// Errors and personal data are not cached publicly
+	rec = getWithHeaders(handler, "/tracks/0", nil)
+	if rec.Header().Get("ETag") != "" || rec.Header().Get("Cache-Control") != "" {
+		t.Errorf("/tracks/0 error has caching headers %v", rec.Header())
+	}
+	rec = getWithHeaders(handler, "/customers", nil)
+	if rec.Header().Get("Cache-Control") != "private, no-cache" ||
+		!strings.Contains(rec.Header().Get("Vary"), "Authorization") {
+		t.Errorf("/customers has wrong caching headers %v", rec.Header())
+	}
+}
+
+// Ensure a response streamed before it is complete is not cached, as it
+// could still fail
+func TestStreamedNotCached(t *testing.T) {
+	config := defaultConfig()
+	config.StreamBuffer = 0
+	server := NewServer(testServer.store, config)
+
+	rec := getWithHeaders(server.routes(), "/tracks?limit=50", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != "" ||
+		rec.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("streamed response returned %v with headers %v", rec.Code,
+			rec.Header())
+	}
+}
+
+func TestETagChangesOnWrite(t *testing.T) {
+	config := defaultConfig()
+	config.DBPath = copyDatabase(t)
+	config.ReadOnly = false
+	store, err := OpenStore(config.storeOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	server := NewServer(store, config)
+
+	etag := getWithHeaders(server.routes(), "/tracks/1", nil).Header().Get(
+		"ETag")
+	rec := sendJSON(server, http.MethodPatch, "/tracks/1",
+		`{"Composer":"Nobody"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("patch returned %v: %s", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("ETag") != "" {
+		t.Errorf("patch response has ETag %q", rec.Header().Get("ETag"))
+	}
+
+	rec = getWithHeaders(server.routes(), "/tracks/1",
+		map[string]string{"If-None-Match": etag})
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag ||
+		!strings.Contains(rec.Body.String(), "Nobody") {
+		t.Errorf("/tracks/1 after a write returned %v with ETag %q: %s",
+			rec.Code, rec.Header().Get("ETag"), rec.Body)
+	}
+}
+
+func TestCompressedETag(t *testing.T) {
+	handler := withCompression(testServer.routes(), 0)
+	rec := getWithHeaders(handler, "/genres",
+		map[string]string{"Accept-Encoding": "gzip"})
+	etag := rec.Header().Get("ETag")
+	plain := getWithHeaders(testServer.routes(), "/genres", nil).Header().Get(
+		"ETag")
+	if rec.Header().Get("Content-Encoding") != "gzip" ||
+		etag != strings.TrimSuffix(plain, `"`)+`-gzip"` {
+		t.Fatalf("compressed /genres has ETag %q, uncompressed %q",
+			etag, plain)
+	}
+
+	rec = getWithHeaders(handler, "/genres",
+		map[string]string{"Accept-Encoding": "gzip", "If-None-Match": etag})
+	if rec.Code != http.StatusNotModified || rec.Header().Get("ETag") != etag {
+		t.Errorf("revalidating compressed /genres returned %v with ETag %q",
+			rec.Code, rec.Header().Get("ETag"))
+	}
+	rec = getWithHeaders(handler, "/genres",
+		map[string]string{"Accept-Encoding": "br", "If-None-Match": etag})
+	if rec.Code != http.StatusOK {
+		t.Errorf("revalidating /genres in another coding returned %v",
+			rec.Code)
+	}
+}
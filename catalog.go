@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// Statements reading the catalog tables other than track
+const (
+	albumSelect     = "SELECT AlbumId, Title, ArtistId FROM album"
+	artistSelect    = "SELECT ArtistId, Name FROM artist"
+	genreSelect     = "SELECT GenreId, Name FROM genre ORDER BY GenreId"
+	mediaTypeSelect = "SELECT MediaTypeId, Name FROM mediatype " +
+		"ORDER BY MediaTypeId"
+)
+
+// Function to create a query builder listing every album, which selects the
+// columns of albumSelect
+func newAlbumQuery() *queryBuilder {
+	q := &queryBuilder{columns: []string{"AlbumId", "Title", "ArtistId"},
+		from: "FROM album"}
+	return q.OrderBy("AlbumId")
+}
+
+// Function to create a query builder listing every artist, which selects
+// the columns of artistSelect
+func newArtistQuery() *queryBuilder {
+	q := &queryBuilder{columns: []string{"ArtistId", "Name"},
+		from: "FROM artist"}
+	return q.OrderBy("ArtistId")
+}
+
+// Function to scan a row of albumSelect
+func scanAlbum(rows *sql.Rows) (interface{}, error) {
+	var a Album
+	err := rows.Scan(&a.AlbumId, &a.Title, &a.ArtistId)
+	return &a, err
+}
+
+// Function to scan a row of artistSelect
+func scanArtist(rows *sql.Rows) (interface{}, error) {
+	var a Artist
+	err := rows.Scan(&a.ArtistId, &a.Name)
+	return &a, err
+}
+
+// Function to scan a row of genreSelect
+func scanGenre(rows *sql.Rows) (interface{}, error) {
+	var g Genre
+	err := rows.Scan(&g.GenreId, &g.Name)
+	return &g, err
+}
+
+// Function to scan a row of mediaTypeSelect
+func scanMediaType(rows *sql.Rows) (interface{}, error) {
+	var m MediaType
+	err := rows.Scan(&m.MediaTypeId, &m.Name)
+	return &m, err
+}
+
+// Function to write a value as a JSON response, indented if the request
+// asked for pretty output
+func writeJSON(w http.ResponseWriter, r *http.Request, status int,
+	v interface{}) {
+	body, err := json.Marshal(v)
+	if prettyJSON(r) {
+		body, err = json.MarshalIndent(v, "", "    ")
+	}
+	if err != nil {
+		logAt(levelError, "Encoding response: "+err.Error())
+		errorHandler(w, r, errEncoding)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// Function to get the id from the request path
+// Ids that are not positive integers cannot name a row, so are reported
+// as not found
+func pathID(r *http.Request) (int64, bool) {
+	return pathInt(r, "id")
+}
+
+// Function to get a positive integer parameter from the request path
+func pathInt(r *http.Request, name string) (int64, bool) {
+	id, err := strconv.ParseInt(pathParam(r, name), 10, 64)
+	return id, err == nil && id > 0
+}
+
+// Function to create the error for a row that does not exist
+func notFound(resource string, r *http.Request) apiError {
+	return errNotFound.withMessage("%s %s not found", resource,
+		pathParam(r, "id"))
+}
+
+// Function to run a query and collect each row read by scan
+func (s *Server) queryRows(ctx context.Context, query string,
+	args []interface{},
+	scan func(*sql.Rows) (interface{}, error)) ([]interface{}, error) {
+	rows, err := s.store.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]interface{}, 0)
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// Function to serve the rows of a query as a JSON array
+func (s *Server) serveRows(w http.ResponseWriter, r *http.Request,
+	query string, args []interface{},
+	scan func(*sql.Rows) (interface{}, error)) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.QueryTimeout)
+	defer cancel()
+
+	items, err := s.queryRows(ctx, query, args, scan)
+	if err != nil {
+		errorHandler(w, r, databaseError(err))
+		return
+	}
+	writeJSON(w, r, http.StatusOK, items)
+}
+
+// Function to serve a page of the rows of a query as a JSON array
+// Accepts limit and offset parameters, and sets the paging headers of
+// /tracks when the rows are paged
+func (s *Server) servePagedRows(w http.ResponseWriter, r *http.Request,
+	q *queryBuilder, scan func(*sql.Rows) (interface{}, error)) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	values := r.URL.Query()
+	limit, hasLimit, e := s.parseLimit(values, s.config.DefaultPageSize)
+	if e != nil {
+		errorHandler(w, r, *e)
+		return
+	}
+	offset, hasOffset, e := parseOffset(values)
+	if e != nil {
+		errorHandler(w, r, *e)
+		return
+	}
+	if hasLimit {
+		q.Limit(limit)
+	}
+	if hasOffset {
+		q.Offset(offset)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.QueryTimeout)
+	defer cancel()
+
+	var page *pageInfo
+	if q.hasLimit {
+		total, err := s.countRows(ctx, q)
+		if err != nil {
+			errorHandler(w, r, databaseError(err))
+			return
+		}
+		info := newPageInfo(r.URL, total, q)
+		page = &info
+	}
+	query, args := q.Build()
+	items, err := s.queryRows(ctx, query, args, scan)
+	if err != nil {
+		errorHandler(w, r, databaseError(err))
+		return
+	}
+	// Paging headers only describe a successful response
+	if page != nil {
+		page.setHeaders(w.Header().Set)
+	}
+	writeJSON(w, r, http.StatusOK, items)
+}
+
+// Function to serve the row with the id in the request path as a JSON
+// object, or a 404 error if there is none
+func (s *Server) serveRow(w http.ResponseWriter, r *http.Request,
+	resource string, query string,
+	scan func(*sql.Rows) (interface{}, error)) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	id, ok := pathID(r)
+	if !ok {
+		errorHandler(w, r, notFound(resource, r))
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.QueryTimeout)
+	defer cancel()
+
+	items, err := s.queryRows(ctx, query, []interface{}{id}, scan)
+	if err != nil {
+		errorHandler(w, r, databaseError(err))
+		return
+	}
+	if len(items) == 0 {
+		errorHandler(w, r, notFound(resource, r))
+		return
+	}
+	writeJSON(w, r, http.StatusOK, items[0])
+}
+
+// Function to check that the row with the id in the request path exists,
+// writing an error response if it does not
+func (s *Server) requireRow(w http.ResponseWriter, r *http.Request,
+	resource string, query string) (int64, bool) {
+	id, ok := pathID(r)
+	if !ok {
+		errorHandler(w, r, notFound(resource, r))
+		return 0, false
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.QueryTimeout)
+	defer cancel()
+
+	var found int
+	err := s.store.QueryRow(ctx, query, []interface{}{id}, &found)
+	if err == sql.ErrNoRows {
+		errorHandler(w, r, notFound(resource, r))
+		return 0, false
+	}
+	if err != nil {
+		errorHandler(w, r, databaseError(err))
+		return 0, false
+	}
+	return id, true
+}
+
+// Request handler function for a single track
+func (s *Server) trackHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getTrack(w, r)
+	case http.MethodPut, http.MethodPatch:
+		s.updateTrack(w, r)
+	case http.MethodDelete:
+		s.deleteTrack(w, r)
+	default:
+		methodNotAllowed(w, r, http.MethodGet, http.MethodPut,
+			http.MethodPatch, http.MethodDelete)
+	}
+}
+
+// Function to write a single track, which accepts the fields and expand
+// parameters of a search
+func (s *Server) getTrack(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r)
+	if !ok {
+		errorHandler(w, r, notFound("Track", r))
+		return
+	}
+
+	params := searchParams{Mode: modeBrowse}
+	values := r.URL.Query()
+	if value := values.Get("fields"); value != "" {
+		fields, ferr := parseFields(value, params.Mode)
+		if ferr != nil {
+			errorHandler(w, r, *ferr)
+			return
+		}
+		params.Fields = fields
+	}
+	if value := values.Get("expand"); value != "" {
+		expansions, xerr := parseExpand(value)
+		if xerr != nil {
+			errorHandler(w, r, *xerr)
+			return
+		}
+		params.Expand = expansions
+	}
+
+	scan := expandScanFields(scanFields(params.Fields), params.Expand)
+	q := newFieldQuery(scan)
+	applyExpansions(q, params.Expand)
+	q.Where("track.TrackId = ?", id)
+	query, args := q.Build()
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.QueryTimeout)
+	defer cancel()
+	items, err := s.queryRows(ctx, query, args,
+		func(rows *sql.Rows) (interface{}, error) {
+			var track Track
+			err := rows.Scan(fieldRefs(&track, scan)...)
+			if view := viewFields(params); view != nil {
+				return trackView{&track, view}, err
+			}
+			return &track, err
+		})
+	if err != nil {
+		errorHandler(w, r, databaseError(err))
+		return
+	}
+	if len(items) == 0 {
+		errorHandler(w, r, notFound("Track", r))
+		return
+	}
+	writeJSON(w, r, http.StatusOK, items[0])
+}
+
+// Request handler function for every album, a page at a time
+func (s *Server) albumsHandler(w http.ResponseWriter, r *http.Request) {
+	s.servePagedRows(w, r, newAlbumQuery(), scanAlbum)
+}
+
+// Request handler function for a single album
+func (s *Server) albumHandler(w http.ResponseWriter, r *http.Request) {
+	s.serveRow(w, r, "Album", albumSelect+" WHERE AlbumId = ?", scanAlbum)
+}
+
+// Request handler function for the tracks of an album, which accepts the
+// parameters of /tracks
+func (s *Server) albumTracksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	id, ok := s.requireRow(w, r, "Album",
+		"SELECT 1 FROM album WHERE AlbumId = ?")
+	if !ok {
+		return
+	}
+	s.serveTracks(w, r, false, filterValue{"track.AlbumId = ?", id})
+}
+
+// Request handler function for every artist, a page at a time
+func (s *Server) artistsHandler(w http.ResponseWriter, r *http.Request) {
+	s.servePagedRows(w, r, newArtistQuery(), scanArtist)
+}
+
+// Request handler function for a single artist
+func (s *Server) artistHandler(w http.ResponseWriter, r *http.Request) {
+	s.serveRow(w, r, "Artist", artistSelect+" WHERE ArtistId = ?",
+		scanArtist)
+}
+
+// Request handler function for the albums of an artist
+func (s *Server) artistAlbumsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	id, ok := s.requireRow(w, r, "Artist",
+		"SELECT 1 FROM artist WHERE ArtistId = ?")
+	if !ok {
+		return
+	}
+	s.serveRows(w, r, albumSelect+" WHERE ArtistId = ? ORDER BY AlbumId",
+		[]interface{}{id}, scanAlbum)
+}
+
+// Request handler function for every genre
+func (s *Server) genresHandler(w http.ResponseWriter, r *http.Request) {
+	s.serveRows(w, r, genreSelect, nil, scanGenre)
+}
+
+// Request handler function for every media type
+func (s *Server) mediaTypesHandler(w http.ResponseWriter, r *http.Request) {
+	s.serveRows(w, r, mediaTypeSelect, nil, scanMediaType)
+}
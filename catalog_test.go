@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Function to request a path from the test server and decode the JSON body
+func getJSON(t *testing.T, target string, status int) interface{} {
+	rec := getWithHeaders(testServer.routes(), target, nil)
+	if rec.Code != status {
+		t.Errorf("%s returned wrong status code: \n\ngot\n\n%v\n\nwant\n\n%v",
+			target, rec.Code, status)
+	}
+	var body interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s returned invalid JSON: %v", target, err)
+	}
+	return body
+}
+
+func TestCatalogEndpoints(t *testing.T) {
+	tests := []struct {
+		url  string
+		want interface{}
+	}{
+		{"/tracks/3070?fields=TrackId,Name&expand=genre",
+			map[string]interface{}{"TrackId": 3070.0, "Name": "Jump",
+				"Genre": map[string]interface{}{"GenreId": 1.0, "Name": "Rock"}}},
+		{"/albums/4", map[string]interface{}{"AlbumId": 4.0,
+			"Title": "Let There Be Rock", "ArtistId": 1.0}},
+		{"/albums/4/tracks?fields=TrackId&limit=2", []interface{}{
+			map[string]interface{}{"TrackId": 15.0},
+			map[string]interface{}{"TrackId": 16.0}}},
+		{"/albums/4/tracks?search=rock&fields=Name", []interface{}{
+			map[string]interface{}{"Name": "Let There Be Rock"}}},
+		{"/albums?limit=2&offset=3", []interface{}{
+			map[string]interface{}{"AlbumId": 4.0,
+				"Title": "Let There Be Rock", "ArtistId": 1.0},
+			map[string]interface{}{"AlbumId": 5.0,
+				"Title": "Big Ones", "ArtistId": 3.0}}},
+		{"/artists?limit=1", []interface{}{
+			map[string]interface{}{"ArtistId": 1.0, "Name": "AC/DC"}}},
+		{"/artists/1", map[string]interface{}{"ArtistId": 1.0,
+			"Name": "AC/DC"}},
+		{"/artists/1/albums", []interface{}{
+			map[string]interface{}{"AlbumId": 1.0,
+				"Title": "For Those About To Rock We Salute You", "ArtistId": 1.0},
+			map[string]interface{}{"AlbumId": 4.0,
+				"Title": "Let There Be Rock", "ArtistId": 1.0}}},
+	}
+	for _, test := range tests {
+		if got := getJSON(t, test.url, http.StatusOK); !reflect.DeepEqual(got,
+			test.want) {
+			t.Errorf("%s returned wrong body: \n\ngot\n\n%v\n\nwant\n\n%v",
+				test.url, got, test.want)
+		}
+	}
+
+	genres, _ := getJSON(t, "/genres", http.StatusOK).([]interface{})
+	if len(genres) != 25 {
+		t.Errorf("wrong number of genres: \n\ngot\n\n%v\n\nwant\n\n%v",
+			len(genres), 25)
+	}
+	mediaTypes, _ := getJSON(t, "/media-types", http.StatusOK).([]interface{})
+	if len(mediaTypes) != 5 {
+		t.Errorf("wrong number of media types: \n\ngot\n\n%v\n\nwant\n\n%v",
+			len(mediaTypes), 5)
+	}
+}
+
+func TestCatalogLists(t *testing.T) {
+	tests := []struct {
+		url   string
+		count int
+		total string
+		link  string
+	}{
+		{"/albums", 100, "347", `</albums?offset=100>; rel="next"`},
+		{"/artists?limit=50&offset=250", 25, "275",
+			`</artists?limit=50&offset=200>; rel="prev"`},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		testServer.routes().ServeHTTP(rec,
+			httptest.NewRequest(http.MethodGet, test.url, nil))
+		var items []interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil {
+			t.Fatalf("%s returned invalid JSON: %v", test.url, err)
+		}
+		if len(items) != test.count ||
+			rec.Header().Get("X-Total-Count") != test.total ||
+			!strings.Contains(rec.Header().Get("Link"), test.link) {
+			t.Errorf("%s returned %d items with headers %v", test.url,
+				len(items), rec.Header())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	testServer.routes().ServeHTTP(rec,
+		httptest.NewRequest(http.MethodGet, "/albums?offset=-1", nil))
+	ResponseErrorTest(rec, "invalid_offset", "offset", t)
+}
+
+func TestCatalogNotFound(t *testing.T) {
+	paths := []string{"/tracks/0", "/tracks/abc", "/tracks/99999",
+		"/albums/999", "/albums/999/tracks", "/artists/999",
+		"/artists/999/albums", "/genres/1/tracks", "/search"}
+	for _, path := range paths {
+		rec := httptest.NewRecorder()
+		testServer.routes().ServeHTTP(rec,
+			httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s returned wrong status code: \n\ngot\n\n%v"+
+				"\n\nwant\n\n%v", path, rec.Code, http.StatusNotFound)
+		}
+		ResponseErrorTest(rec, "not_found", "", t)
+	}
+
+	rec := httptest.NewRecorder()
+	testServer.routes().ServeHTTP(rec,
+		httptest.NewRequest(http.MethodPost, "/genres", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("wrong status code: \n\ngot\n\n%v\n\nwant\n\n%v",
+			rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+// Ensure a value that cannot be encoded gives a JSON error body
+func TestWriteJSONError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeJSON(rec, httptest.NewRequest(http.MethodGet, "/genres", nil),
+		http.StatusOK, make(chan int))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("wrong status code: \n\ngot\n\n%v\n\nwant\n\n%v",
+			rec.Code, http.StatusInternalServerError)
+	}
+	ResponseErrorTest(rec, "encoding_error", "", t)
+}
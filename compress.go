@@ -0,0 +1,277 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressEncoder is a writer producing one content coding
+type compressEncoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressor is a content coding the server can produce, with a pool of
+// encoders so each response does not allocate new compression state
+type compressor struct {
+	name string
+	pool *sync.Pool
+}
+
+// Every content coding in order of preference when the client weights
+// them equally
+// HTTP's deflate coding is the zlib format, not raw deflate
+var compressors = []compressor{
+	{"br", &sync.Pool{New: func() interface{} {
+		return brotli.NewWriterLevel(nil, brotli.DefaultCompression)
+	}}},
+	{"gzip", &sync.Pool{New: func() interface{} {
+		return gzip.NewWriter(nil)
+	}}},
+	{"deflate", &sync.Pool{New: func() interface{} {
+		return zlib.NewWriter(nil)
+	}}},
+}
+
+// Media types worth compressing, other types such as images are usually
+// compressed already
+var compressibleTypes = []string{"text/", "application/json",
+	"application/xml", "application/x-ndjson", "application/msgpack",
+	"application/javascript", "image/svg+xml"}
+
+// Function to choose the content coding for an Accept-Encoding header
+// Codings are weighted by their q value, * weights every coding not named
+// and ties go to the earlier coding in compressors
+func negotiateEncoding(header string) (compressor, bool) {
+	weights := make(map[string]float64)
+	wildcard, hasWildcard := 0.0, false
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				v, err := strconv.ParseFloat(param[2:], 64)
+				if err != nil || v < 0 || v > 1 {
+					v = 0
+				}
+				q = v
+			}
+		}
+		if name == "*" {
+			wildcard, hasWildcard = q, true
+		} else {
+			weights[name] = q
+		}
+	}
+
+	best, bestQ := -1, 0.0
+	for i, c := range compressors {
+		q, ok := weights[c.name]
+		if !ok && hasWildcard {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = i, q
+		}
+	}
+	if best < 0 {
+		return compressor{}, false
+	}
+	return compressors[best], true
+}
+
+// compressWriter compresses a response once it is known to be worth it
+// Output is held until minSize bytes have been written, so a response
+// that ends sooner is sent as it is. A flush also starts compression, as
+// it means a streamed response has more to follow
+// A compressed response is a different representation, so its entity tag
+// is marked with the coding. codedMatch records that the request's
+// If-None-Match named such a tag, so a 304 response gives it back
+type compressWriter struct {
+	http.ResponseWriter
+	compressor compressor
+	minSize    int
+	status     int
+	buf        []byte
+	decided    bool
+	codedMatch bool
+	enc        compressEncoder
+}
+
+// WriteHeader records the status, which is sent once the encoding is
+// decided
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+// Write implements http.ResponseWriter
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) >= cw.minSize {
+			if err := cw.start(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush implements http.Flusher, sending everything written so far
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.start(true)
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Function to check whether a response can be compressed from its status
+// and headers
+func compressible(status int, h http.Header) bool {
+	if status < http.StatusOK || status == http.StatusNoContent ||
+		status == http.StatusNotModified || h.Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := h.Get("Content-Type")
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Function to send the status and buffered output, compressing from here
+// on if compress is set and the response allows it
+func (cw *compressWriter) start(compress bool) error {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	h := cw.Header()
+	// The type must be known before the body is compressed, as it can no
+	// longer be sniffed from the body afterwards
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	if compress && compressible(cw.status, h) {
+		h.Set("Content-Encoding", cw.compressor.name)
+		h.Del("Content-Length")
+		codeETag(h, cw.compressor.name)
+		cw.enc = cw.compressor.pool.Get().(compressEncoder)
+		cw.enc.Reset(cw.ResponseWriter)
+	} else if cw.status == http.StatusNotModified && cw.codedMatch {
+		codeETag(h, cw.compressor.name)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Function to mark the entity tag in a response's headers with a content
+// coding, so "abc" becomes "abc-gzip"
+func codeETag(h http.Header, coding string) {
+	etag := h.Get("ETag")
+	if strings.HasSuffix(etag, `"`) {
+		h.Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+coding+`"`)
+	}
+}
+
+// Function to remove the mark of a content coding from the entity tags in
+// an If-None-Match header, reporting whether any tag had it
+func uncodeETags(header string, coding string) (string, bool) {
+	suffix := "-" + coding + `"`
+	tags := strings.Split(header, ",")
+	found := false
+	for i, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if strings.HasSuffix(tag, suffix) {
+			tag = strings.TrimSuffix(tag, suffix) + `"`
+			found = true
+		}
+		tags[i] = tag
+	}
+	return strings.Join(tags, ", "), found
+}
+
+// Function to end the response once the handler has returned
+func (cw *compressWriter) finish() {
+	if !cw.decided {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			// Nothing was written, so leave the defaults to net/http
+			return
+		}
+		cw.start(false)
+	}
+	if cw.enc != nil {
+		cw.enc.Close()
+		cw.compressor.pool.Put(cw.enc)
+		cw.enc = nil
+	}
+}
+
+// Middleware that compresses responses of at least minSize bytes with the
+// best content coding the client accepts
+func withCompression(next http.Handler, minSize int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		c, ok := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if !ok || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, compressor: c,
+			minSize: minSize}
+		if header := r.Header.Get("If-None-Match"); header != "" {
+			if tags, ok := uncodeETags(header, c.name); ok {
+				r = r.Clone(r.Context())
+				r.Header.Set("If-None-Match", tags)
+				cw.codedMatch = true
+			}
+		}
+		defer cw.finish()
+		next.ServeHTTP(cw, r)
+	})
+}
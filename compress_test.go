@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"gzip, deflate, br", "br"},
+		{"gzip;q=0.8, br;q=0.5", "gzip"},
+		{"GZIP;q=1.0, deflate;q=0.9", "gzip"},
+		{"*", "br"},
+		{"*;q=0.5, br;q=0, gzip;q=0.1", "deflate"},
+		{"br;q=0, gzip;q=0, deflate;q=0", ""},
+		{"compress", ""},
+	}
+	for _, test := range tests {
+		c, ok := negotiateEncoding(test.header)
+		if got := c.name; got != test.want || ok != (test.want != "") {
+			t.Errorf("%q chose wrong encoding: \n\ngot\n\n%q\n\nwant\n\n%q",
+				test.header, got, test.want)
+		}
+	}
+}
+
+// Function to decode a body in a content coding
+func decompress(t *testing.T, encoding string, body []byte) []byte {
+	var r io.Reader
+	var err error
+	switch encoding {
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(body))
+	default:
+		return body
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("%s body could not be decoded: %v", encoding, err)
+	}
+	return out
+}
+
+func TestCompression(t *testing.T) {
+	const target = "/?search=a&limit=200"
+	handler := withCompression(testServer.routes(), 1024)
+	plain := getWithHeaders(handler, target, nil)
+	if plain.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("response compressed without Accept-Encoding")
+	}
+
+	for _, encoding := range []string{"gzip", "deflate", "br"} {
+		rec := getWithHeaders(handler, target,
+			map[string]string{"Accept-Encoding": encoding})
+		if got := rec.Header().Get("Content-Encoding"); got != encoding {
+			t.Errorf("wrong Content-Encoding: \n\ngot\n\n%v\n\nwant\n\n%v",
+				got, encoding)
+		}
+		if rec.Body.Len() >= plain.Body.Len() {
+			t.Errorf("%s body is not smaller: %d bytes, %d uncompressed",
+				encoding, rec.Body.Len(), plain.Body.Len())
+		}
+		if !bytes.Equal(decompress(t, encoding, rec.Body.Bytes()),
+			plain.Body.Bytes()) {
+			t.Errorf("%s body does not decode to the uncompressed body",
+				encoding)
+		}
+		if got := rec.Header().Values("Vary"); len(got) == 0 ||
+			got[0] != "Accept-Encoding" {
+			t.Errorf("wrong Vary header: %v", got)
+		}
+	}
+
+	// Small responses and errors below the minimum are sent as they are
+	for _, small := range []string{"/albums/4", "/albums/99"} {
+		rec := getWithHeaders(handler, small,
+			map[string]string{"Accept-Encoding": "gzip"})
+		if rec.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%s was compressed below the minimum size", small)
+		}
+	}
+}
+
+// Ensure a streamed response is compressed as it is flushed and keeps its
+// trailers
+func TestCompressionStreaming(t *testing.T) {
+	config := defaultConfig()
+	config.StreamBuffer = 0
+	server := NewServer(testServer.store, config)
+
+	const target = "/tracks?limit=50"
+	handler := withCompression(server.routes(), 1024)
+	plain := getWithHeaders(handler, target, nil)
+	rec := getWithHeaders(handler, target,
+		map[string]string{"Accept-Encoding": "gzip"})
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("streamed response was not compressed")
+	}
+	if !rec.Flushed {
+		t.Errorf("streamed response was not flushed")
+	}
+	if !bytes.Equal(decompress(t, "gzip", rec.Body.Bytes()),
+		plain.Body.Bytes()) {
+		t.Errorf("streamed body does not decode to the uncompressed body")
+	}
+	if rec.Result().Trailer.Get(nextCursorHeader) == "" {
+		t.Errorf("streamed response lost the %s trailer", nextCursorHeader)
+	}
+}
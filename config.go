@@ -0,0 +1,349 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting the server reads at startup
+// Settings are applied in order of precedence: defaults, then the config
+// file, then environment variables, then command line flags
+type Config struct {
+	ListenAddr      string
+	DBPath          string
+	FaviconPath     string
+	LogLevel        string
+	DefaultPageSize int
+	MaxPageSize     int
+	FuzzyThreshold  float64
+	MaxOpenConns    int
+	StreamBuffer    int
+	CompressMinSize int
+	ReadOnly        bool
+	CursorSecret    string
+	APIToken        string
+	CacheControl    string
+	BusyTimeout     time.Duration
+	QueryTimeout    time.Duration
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+}
+
+// Function to get the settings used when nothing else is configured
+func defaultConfig() Config {
+	return Config{
+		ListenAddr:      ":4041",
+		DBPath:          "./Chinook_Sqlite.sqlite",
+		FaviconPath:     "./note.ico",
+		LogLevel:        "info",
+		DefaultPageSize: 100,
+		MaxPageSize:     1000,
+		FuzzyThreshold:  0.7,
+		MaxOpenConns:    4,
+		StreamBuffer:    64 << 10,
+		CompressMinSize: 1 << 10,
+		ReadOnly:        true,
+		CacheControl:    defaultCacheControl,
+		BusyTimeout:     5 * time.Second,
+		QueryTimeout:    10 * time.Second,
+		ReadTimeout:     5 * time.Second,
+		WriteTimeout:    30 * time.Second,
+		IdleTimeout:     60 * time.Second,
+	}
+}
+
+// Prefix added to a setting name to get its environment variable
+const envPrefix = "CHINOOK_"
+
+// setting describes one configurable value
+// The name is used as the flag name and config file key, and in upper case
+// with envPrefix as the environment variable
+type setting struct {
+	name  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+// Function to create a setter for a string field
+func stringSetting(field func(c *Config) *string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+// Function to create a setter for an int field
+func intSetting(field func(c *Config) *int) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+// Function to create a setter for a float field
+func floatSetting(field func(c *Config) *float64) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+// Function to create a setter for a bool field
+func boolSetting(field func(c *Config) *bool) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+// Function to create a setter for a duration field such as "5s"
+func durationSetting(
+	field func(c *Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", value)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+// Every setting that can be given in a config file, environment variable
+// or flag
+var settings = []setting{
+	{"listen_addr", "address to listen on",
+		stringSetting(func(c *Config) *string { return &c.ListenAddr })},
+	{"db_path", "path to the Chinook SQLite database",
+		stringSetting(func(c *Config) *string { return &c.DBPath })},
+	{"favicon_path", "path to the favicon",
+		stringSetting(func(c *Config) *string { return &c.FaviconPath })},
+	{"log_level", "minimum log level: debug, info, warn or error",
+		stringSetting(func(c *Config) *string { return &c.LogLevel })},
+	{"default_page_size", "tracks returned when no limit is given, 0 for all",
+		intSetting(func(c *Config) *int { return &c.DefaultPageSize })},
+	{"max_page_size", "largest limit accepted, 0 for no maximum",
+		intSetting(func(c *Config) *int { return &c.MaxPageSize })},
+	{"fuzzy_threshold", "lowest score from 0 to 1 matched by a fuzzy search",
+		floatSetting(func(c *Config) *float64 { return &c.FuzzyThreshold })},
+	{"max_open_conns", "maximum open database connections",
+		intSetting(func(c *Config) *int { return &c.MaxOpenConns })},
+	{"stream_buffer", "bytes of results buffered before streaming begins",
+		intSetting(func(c *Config) *int { return &c.StreamBuffer })},
+	{"compress_min_size", "smallest response in bytes that is compressed, " +
+		"-1 to never compress",
+		intSetting(func(c *Config) *int { return &c.CompressMinSize })},
+	{"read_only", "open the database read-only",
+		boolSetting(func(c *Config) *bool { return &c.ReadOnly })},
+	{"cursor_secret", "key used to sign pagination cursors, random if empty",
+		stringSetting(func(c *Config) *string { return &c.CursorSecret })},
+	{"api_token", "bearer token allowing personal data to be read, " +
+		"none if empty",
+		stringSetting(func(c *Config) *string { return &c.APIToken })},
+	{"cache_control", "Cache-Control header of GET responses by path, " +
+		"as path=directives separated by semicolons",
+		stringSetting(func(c *Config) *string { return &c.CacheControl })},
+	{"busy_timeout", "how long to wait on a locked database",
+		durationSetting(func(c *Config) *time.Duration { return &c.BusyTimeout })},
+	{"query_timeout", "maximum time for a database query",
+		durationSetting(func(c *Config) *time.Duration { return &c.QueryTimeout })},
+	{"read_timeout", "maximum time to read a request",
+		durationSetting(func(c *Config) *time.Duration { return &c.ReadTimeout })},
+	{"write_timeout", "maximum time to write a response",
+		durationSetting(func(c *Config) *time.Duration { return &c.WriteTimeout })},
+	{"idle_timeout", "how long to keep idle connections open",
+		durationSetting(func(c *Config) *time.Duration { return &c.IdleTimeout })},
+}
+
+// Function to get the environment variable name for a setting
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(name)
+}
+
+// LoadConfig reads the configuration from the command line arguments,
+// the environment and the config file named by -config or CHINOOK_CONFIG
+// The config file may be YAML or JSON
+func LoadConfig(args []string, getenv func(string) string) (Config, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configPath := fs.String("config", getenv(envName("config")),
+		"path to a YAML or JSON config file")
+	flagValues := make(map[string]*string)
+	for _, s := range settings {
+		flagValues[s.name] = fs.String(s.name, "", s.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	// Config file
+	if *configPath != "" {
+		values, err := readConfigFile(*configPath)
+		if err != nil {
+			return cfg, err
+		}
+		for _, s := range settings {
+			value, ok := values[s.name]
+			if !ok {
+				continue
+			}
+			if err := s.set(&cfg, value); err != nil {
+				return cfg, fmt.Errorf("config file %s: %s: %w",
+					*configPath, s.name, err)
+			}
+			delete(values, s.name)
+		}
+		for name := range values {
+			return cfg, fmt.Errorf("config file %s: unknown setting %q",
+				*configPath, name)
+		}
+	}
+
+	// Environment variables
+	for _, s := range settings {
+		value := getenv(envName(s.name))
+		if value == "" {
+			continue
+		}
+		if err := s.set(&cfg, value); err != nil {
+			return cfg, fmt.Errorf("%s: %w", envName(s.name), err)
+		}
+	}
+
+	// Flags, only those given on the command line
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.name != f.Name || flagErr != nil {
+				continue
+			}
+			if err := s.set(&cfg, *flagValues[s.name]); err != nil {
+				flagErr = fmt.Errorf("-%s: %w", s.name, err)
+			}
+		}
+	})
+	if flagErr != nil {
+		return cfg, flagErr
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// Function to read a config file into a map of setting names to values
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	// JSON is a subset of YAML so one decoder handles both formats
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("config file %s: %s must be a single value",
+				path, name)
+		}
+		values[name] = fmt.Sprint(value)
+	}
+	return values, nil
+}
+
+// Validate checks that the settings can be used to start the server
+// Every problem found is reported in the returned error
+func (c Config) Validate() error {
+	var problems []string
+
+	if c.ListenAddr == "" {
+		problems = append(problems, "listen_addr must not be empty")
+	}
+	if c.DBPath == "" {
+		problems = append(problems, "db_path must not be empty")
+	} else if _, err := os.Stat(c.DBPath); err != nil {
+		problems = append(problems, fmt.Sprintf("db_path: %v", err))
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		problems = append(problems, "log_level: "+err.Error())
+	}
+	if c.DefaultPageSize < 0 {
+		problems = append(problems, "default_page_size must not be negative")
+	}
+	if c.MaxPageSize < 0 {
+		problems = append(problems, "max_page_size must not be negative")
+	}
+	if c.MaxPageSize > 0 && c.DefaultPageSize > c.MaxPageSize {
+		problems = append(problems,
+			"default_page_size must not be larger than max_page_size")
+	}
+	if c.FuzzyThreshold < 0 || c.FuzzyThreshold > 1 {
+		problems = append(problems, "fuzzy_threshold must be from 0 to 1")
+	}
+	if c.StreamBuffer < 0 {
+		problems = append(problems, "stream_buffer must not be negative")
+	}
+	if c.CompressMinSize < -1 {
+		problems = append(problems, "compress_min_size must be at least -1")
+	}
+	if _, err := parseCacheRules(c.CacheControl); err != nil {
+		problems = append(problems, "cache_control: "+err.Error())
+	}
+	if c.MaxOpenConns < 1 {
+		problems = append(problems, "max_open_conns must be at least 1")
+	}
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"busy_timeout", c.BusyTimeout},
+		{"query_timeout", c.QueryTimeout},
+		{"read_timeout", c.ReadTimeout},
+		{"write_timeout", c.WriteTimeout},
+		{"idle_timeout", c.IdleTimeout},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			problems = append(problems, d.name+" must not be negative")
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s",
+			strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Function to get the database options for the configured settings
+func (c Config) storeOptions() StoreOptions {
+	return StoreOptions{
+		Path:         c.DBPath,
+		MaxOpenConns: c.MaxOpenConns,
+		BusyTimeout:  c.BusyTimeout,
+		ReadOnly:     c.ReadOnly,
+	}
+}
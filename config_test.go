@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Function to create a getenv function backed by a map
+func testEnv(vars map[string]string) func(string) string {
+	return func(name string) string {
+		return vars[name]
+	}
+}
+
+// Function to write a config file into a temporary directory
+func writeConfigFile(t *testing.T, name string, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	config, err := LoadConfig(nil, testEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config != defaultConfig() {
+		t.Errorf("config does not match defaults: \n\ngot\n\n%+v\n\nwant\n\n%+v",
+			config, defaultConfig())
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", "listen_addr: \":5000\"\n"+
+		"log_level: debug\n"+
+		"max_page_size: 500\n"+
+		"query_timeout: 2s\n")
+
+	// Flags override environment variables which override the config file
+	env := testEnv(map[string]string{
+		"CHINOOK_CONFIG":      path,
+		"CHINOOK_LISTEN_ADDR": ":6000",
+		"CHINOOK_LOG_LEVEL":   "warn",
+	})
+	config, err := LoadConfig([]string{"-listen_addr", ":7000"}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.ListenAddr != ":7000" {
+		t.Errorf("listen_addr: got %q, want %q", config.ListenAddr, ":7000")
+	}
+	if config.LogLevel != "warn" {
+		t.Errorf("log_level: got %q, want %q", config.LogLevel, "warn")
+	}
+	if config.MaxPageSize != 500 {
+		t.Errorf("max_page_size: got %v, want %v", config.MaxPageSize, 500)
+	}
+	if config.QueryTimeout != 2*time.Second {
+		t.Errorf("query_timeout: got %v, want %v", config.QueryTimeout,
+			2*time.Second)
+	}
+}
+
+func TestLoadConfigJSONFile(t *testing.T) {
+	path := writeConfigFile(t, "config.json",
+		`{"db_path": "./Chinook_Sqlite.sqlite", "read_only": true, `+
+			`"default_page_size": 10}`)
+
+	config, err := LoadConfig([]string{"-config", path}, testEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.DefaultPageSize != 10 {
+		t.Errorf("default_page_size: got %v, want %v",
+			config.DefaultPageSize, 10)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	unknown := writeConfigFile(t, "unknown.yaml", "listen_port: 4041\n")
+
+	tests := []struct {
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{[]string{"-max_page_size", "ten"}, nil, "-max_page_size"},
+		{nil, map[string]string{"CHINOOK_BUSY_TIMEOUT": "5"},
+			"CHINOOK_BUSY_TIMEOUT"},
+		{[]string{"-config", unknown}, nil, "unknown setting"},
+		{[]string{"-db_path", "./missing.sqlite"}, nil, "db_path"},
+		{[]string{"-log_level", "loud"}, nil, "log_level"},
+		{[]string{"-fuzzy_threshold", "1.5"}, nil,
+			"fuzzy_threshold must be from 0 to 1"},
+		{[]string{"-default_page_size", "20", "-max_page_size", "10"}, nil,
+			"default_page_size must not be larger than max_page_size"},
+		{[]string{"-cache_control", "tracks=no-store"}, nil,
+			"cache_control"},
+	}
+
+	for _, test := range tests {
+		_, err := LoadConfig(test.args, testEnv(test.env))
+		if err == nil {
+			t.Errorf("args %v env %v: expected error, got nil",
+				test.args, test.env)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("args %v env %v: error %q does not mention %q",
+				test.args, test.env, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// cursor marks the last track of a page by its position in the search
+// ordering, so the next page can start directly after it
+// The search term is included so a cursor cannot be reused for a
+// different search
+type cursor struct {
+	Search  string `json:"s"`
+	Rank    int    `json:"r"`
+	Name    string `json:"n"`
+	TrackId int64  `json:"i"`
+}
+
+// Error returned for cursors that were not issued by this server
+var errBadCursor = errors.New("cursor is malformed or has been tampered with")
+
+// Function to create a random key for signing cursors
+func newCursorSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
+
+// Function to sign a cursor payload
+func signCursor(secret []byte, payload []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Function to encode a cursor as an opaque URL-safe token
+// The token is the JSON payload and its HMAC, each base64 encoded
+func encodeCursor(secret []byte, c cursor) string {
+	payload, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(signCursor(secret, payload))
+}
+
+// Function to decode a token created by encodeCursor and check its signature
+func decodeCursor(secret []byte, token string) (cursor, error) {
+	var c cursor
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return c, errBadCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return c, errBadCursor
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return c, errBadCursor
+	}
+	if !hmac.Equal(signature, signCursor(secret, payload)) {
+		return c, errBadCursor
+	}
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return c, errBadCursor
+	}
+	return c, nil
+}
+
+// Function to create the cursor pointing after a track in a search
+func cursorAfter(search string, track *Track) cursor {
+	return cursor{
+		Search:  search,
+		Rank:    searchRank(track.Name.String, search),
+		Name:    track.Name.String,
+		TrackId: track.TrackId.Int64,
+	}
+}
@@ -0,0 +1,594 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// trackEncoder writes a stream of tracks in one output format
+// Tracks are written as they are read, so an encoder writes the start of
+// the output, then each track, then the end. Track values are either a
+// Track or a trackView, and every format is built from their JSON so the
+// fields always match the JSON output
+type trackEncoder interface {
+	// ContentType gives the Content-Type header of the output
+	ContentType() string
+	// Begin writes the start of the output, template is a track with the
+	// fields every track will have, for formats with a header
+	Begin(buf *bytes.Buffer, template interface{}) error
+	// Encode writes a track, index counts the tracks already written
+	Encode(buf *bytes.Buffer, track interface{}, index int) error
+	// Fail writes an error that stopped the stream, if the format can
+	// represent one, before End is called
+	Fail(buf *bytes.Buffer, e apiError, index int)
+	// End writes the end of the output
+	End(buf *bytes.Buffer)
+}
+
+// trackFormat is an output format that can be chosen with the Accept
+// header or the format parameter
+// The first media type is sent as the Content-Type, the others are
+// accepted as aliases
+type trackFormat struct {
+	name       string
+	mediaTypes []string
+	encoder    func() trackEncoder
+}
+
+// Every output format, the first is used when the client has no preference
+var trackFormats = []trackFormat{
+	{"json", []string{"application/json"},
+		func() trackEncoder { return &jsonEncoder{prefix: "[", suffix: "]"} }},
+	{"csv", []string{"text/csv"},
+		func() trackEncoder { return &csvEncoder{} }},
+	{"ndjson", []string{"application/x-ndjson", "application/ndjson"},
+		func() trackEncoder { return ndjsonEncoder{} }},
+	{"xml", []string{"application/xml", "text/xml"},
+		func() trackEncoder { return xmlEncoder{} }},
+	{"msgpack", []string{"application/msgpack", "application/x-msgpack",
+		"application/vnd.msgpack"},
+		func() trackEncoder { return msgpackEncoder{} }},
+}
+
+// Function to list the format names, for messages
+func trackFormatNames() string {
+	names := make([]string, len(trackFormats))
+	for i, f := range trackFormats {
+		names[i] = f.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// Function to choose the output format of a request
+// The format parameter overrides the Accept header. Otherwise JSON is used
+// unless another format's media type is listed in Accept with the highest
+// weight given, and JSON with a lower one. Wildcards only ever select JSON,
+// so a browser's Accept header gets JSON, and a header naming nothing that
+// can be produced also gets JSON rather than an error
+func negotiateFormat(r *http.Request) (trackFormat, *apiError) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		for _, f := range trackFormats {
+			if strings.EqualFold(f.name, name) {
+				return f, nil
+			}
+		}
+		e := errNotAcceptable.withMessage(
+			"Unknown format %q, must be one of %s", name, trackFormatNames())
+		e.Field = "format"
+		return trackFormat{}, &e
+	}
+
+	ranges := parseAccept(strings.Join(r.Header.Values("Accept"), ","))
+	top := 0.0
+	for _, mr := range ranges {
+		if mr.q > top {
+			top = mr.q
+		}
+	}
+	if top == 0 || acceptWeight(ranges, trackFormats[0].mediaTypes[0]) >= top {
+		return trackFormats[0], nil
+	}
+	for _, f := range trackFormats[1:] {
+		for _, mediaType := range f.mediaTypes {
+			for _, mr := range ranges {
+				if mr.mediaType == mediaType && mr.q == top {
+					return f, nil
+				}
+			}
+		}
+	}
+	return trackFormats[0], nil
+}
+
+// mediaRange is a media type from an Accept header and its weight
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+// Function to parse the media ranges of an Accept header
+// Ranges that cannot be parsed are ignored, as is a q value out of range
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{mediaType, q})
+	}
+	return ranges
+}
+
+// Function to get the weight of a media type from the most specific range
+// matching it, 0 if none match
+func acceptWeight(ranges []mediaRange, mediaType string) float64 {
+	major := mediaType[:strings.Index(mediaType, "/")]
+	q, specificity := 0.0, 0
+	for _, mr := range ranges {
+		s := 0
+		switch mr.mediaType {
+		case mediaType:
+			s = 3
+		case major + "/*":
+			s = 2
+		case "*/*":
+			s = 1
+		}
+		if s > specificity {
+			q, specificity = mr.q, s
+		}
+	}
+	return q
+}
+
+// member is a name and value of a JSON object, kept in order
+// Values are nil, bool, string, json.Number, []member for an object or
+// []interface{} for an array
+type member struct {
+	name  string
+	value interface{}
+}
+
+// Function to encode a value as JSON and decode it keeping the order of
+// object members
+func orderedJSON(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return decodeOrdered(dec)
+}
+
+// Function to decode the next value from a decoder, see orderedJSON
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		members := []member{}
+		for dec.More() {
+			name, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, member{name.(string), value})
+		}
+		_, err = dec.Token()
+		return members, err
+	case json.Delim('['):
+		values := []interface{}{}
+		for dec.More() {
+			value, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		_, err = dec.Token()
+		return values, err
+	}
+	return token, nil
+}
+
+// Function to get the members of the error object written in a stream
+func errorMembers(e apiError) []member {
+	members := []member{{"code", e.Code}, {"message", e.Message}}
+	if e.Field != "" {
+		members = append(members, member{"field", e.Field})
+	}
+	if e.RequestID != "" {
+		members = append(members, member{"request_id", e.RequestID})
+	}
+	return []member{{"error", members}}
+}
+
+// jsonEncoder writes tracks as a JSON array, compact unless pretty is set
+// The array is written between prefix and suffix so it can be embedded in
+// a larger object
+type jsonEncoder struct {
+	prefix string
+	suffix string
+	pretty bool
+}
+
+// ContentType implements trackEncoder
+func (je *jsonEncoder) ContentType() string {
+	return "application/json"
+}
+
+// Begin implements trackEncoder
+func (je *jsonEncoder) Begin(buf *bytes.Buffer, template interface{}) error {
+	buf.WriteString(je.prefix)
+	return nil
+}
+
+// Encode implements trackEncoder
+func (je *jsonEncoder) Encode(buf *bytes.Buffer, track interface{},
+	index int) error {
+	item, err := json.Marshal(track)
+	if je.pretty {
+		item, err = json.MarshalIndent(track, "", "    ")
+	}
+	if err != nil {
+		return err
+	}
+	// On first track, omit comma for array
+	if index > 0 {
+		je.separate(buf)
+	}
+	buf.Write(item)
+	return nil
+}
+
+// Function to write the separator between items of the array
+func (je *jsonEncoder) separate(buf *bytes.Buffer) {
+	buf.WriteByte(',')
+	if je.pretty {
+		buf.WriteByte('\n')
+	}
+}
+
+// Fail implements trackEncoder, adding the error object to the array
+func (je *jsonEncoder) Fail(buf *bytes.Buffer, e apiError, index int) {
+	body, _ := json.Marshal(struct {
+		Error apiError `json:"error"`
+	}{e})
+	if index > 0 {
+		je.separate(buf)
+	}
+	buf.Write(body)
+}
+
+// End implements trackEncoder
+func (je *jsonEncoder) End(buf *bytes.Buffer) {
+	buf.WriteString(je.suffix)
+}
+
+// ndjsonEncoder writes each track as a line of compact JSON
+type ndjsonEncoder struct{}
+
+// ContentType implements trackEncoder
+func (ndjsonEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+// Begin implements trackEncoder
+func (ndjsonEncoder) Begin(buf *bytes.Buffer, template interface{}) error {
+	return nil
+}
+
+// Encode implements trackEncoder
+func (ndjsonEncoder) Encode(buf *bytes.Buffer, track interface{},
+	index int) error {
+	line, err := json.Marshal(track)
+	if err != nil {
+		return err
+	}
+	buf.Write(line)
+	buf.WriteByte('\n')
+	return nil
+}
+
+// Fail implements trackEncoder, adding the error object as the last line
+func (ndjsonEncoder) Fail(buf *bytes.Buffer, e apiError, index int) {
+	body, _ := json.Marshal(struct {
+		Error apiError `json:"error"`
+	}{e})
+	buf.Write(body)
+	buf.WriteByte('\n')
+}
+
+// End implements trackEncoder
+func (ndjsonEncoder) End(buf *bytes.Buffer) {}
+
+// csvEncoder writes tracks as CSV with a header row
+// Expanded objects are flattened into columns such as Genre.Name and null
+// is written as an empty cell
+type csvEncoder struct{}
+
+// ContentType implements trackEncoder
+func (*csvEncoder) ContentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+// Function to flatten a decoded object into column names and cells
+func flattenCSV(prefix string, value interface{}, names []string,
+	cells []string) ([]string, []string) {
+	if members, ok := value.([]member); ok {
+		for _, m := range members {
+			names, cells = flattenCSV(prefix+m.name+".", m.value, names, cells)
+		}
+		return names, cells
+	}
+	name := strings.TrimSuffix(prefix, ".")
+	switch v := value.(type) {
+	case nil:
+		return append(names, name), append(cells, "")
+	case string:
+		return append(names, name), append(cells, v)
+	case json.Number:
+		return append(names, name), append(cells, v.String())
+	case bool:
+		return append(names, name), append(cells, strconv.FormatBool(v))
+	}
+	// Arrays are not split into columns, but written as JSON
+	data, _ := json.Marshal(plainJSON(value))
+	return append(names, name), append(cells, string(data))
+}
+
+// Function to convert a decoded value back to values encoding/json writes
+// Objects become maps, so the order of their members is lost
+func plainJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []member:
+		object := make(map[string]interface{}, len(v))
+		for _, m := range v {
+			object[m.name] = plainJSON(m.value)
+		}
+		return object
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, item := range v {
+			values[i] = plainJSON(item)
+		}
+		return values
+	}
+	return value
+}
+
+// Function to write one row of CSV
+func writeCSV(buf *bytes.Buffer, cells []string) error {
+	w := csv.NewWriter(buf)
+	if err := w.Write(cells); err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// Begin implements trackEncoder, writing the header row
+func (*csvEncoder) Begin(buf *bytes.Buffer, template interface{}) error {
+	if template == nil {
+		return nil
+	}
+	value, err := orderedJSON(template)
+	if err != nil {
+		return err
+	}
+	names, _ := flattenCSV("", value, nil, nil)
+	return writeCSV(buf, names)
+}
+
+// Encode implements trackEncoder
+func (*csvEncoder) Encode(buf *bytes.Buffer, track interface{},
+	index int) error {
+	value, err := orderedJSON(track)
+	if err != nil {
+		return err
+	}
+	_, cells := flattenCSV("", value, nil, nil)
+	return writeCSV(buf, cells)
+}
+
+// Fail implements trackEncoder
+// CSV has no place for an error, which is only given in the trailer
+func (*csvEncoder) Fail(buf *bytes.Buffer, e apiError, index int) {}
+
+// End implements trackEncoder
+func (*csvEncoder) End(buf *bytes.Buffer) {}
+
+// xmlEncoder writes tracks as Track elements of a Tracks document
+// Each field is a child element, expanded objects have child elements of
+// their own and null fields are left out
+type xmlEncoder struct{}
+
+// ContentType implements trackEncoder
+func (xmlEncoder) ContentType() string {
+	return "application/xml"
+}
+
+// Begin implements trackEncoder
+func (xmlEncoder) Begin(buf *bytes.Buffer, template interface{}) error {
+	buf.WriteString(xml.Header)
+	buf.WriteString("<Tracks>")
+	return nil
+}
+
+// Function to write a decoded value as the content of an element
+func writeXML(buf *bytes.Buffer, name string, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		return
+	case []member:
+		buf.WriteString("<" + name + ">")
+		for _, m := range v {
+			writeXML(buf, m.name, m.value)
+		}
+		buf.WriteString("</" + name + ">")
+	case []interface{}:
+		for _, item := range v {
+			writeXML(buf, name, item)
+		}
+	default:
+		buf.WriteString("<" + name + ">")
+		xml.EscapeText(buf, []byte(scalarText(v)))
+		buf.WriteString("</" + name + ">")
+	}
+}
+
+// Function to get the text of a decoded string, number or bool
+func scalarText(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+// Encode implements trackEncoder
+func (xmlEncoder) Encode(buf *bytes.Buffer, track interface{},
+	index int) error {
+	value, err := orderedJSON(track)
+	if err != nil {
+		return err
+	}
+	writeXML(buf, "Track", value)
+	return nil
+}
+
+// Fail implements trackEncoder, adding an Error element after the tracks
+func (xmlEncoder) Fail(buf *bytes.Buffer, e apiError, index int) {
+	members := errorMembers(e)[0].value.([]member)
+	writeXML(buf, "Error", members)
+}
+
+// End implements trackEncoder
+func (xmlEncoder) End(buf *bytes.Buffer) {
+	buf.WriteString("</Tracks>")
+}
+
+// msgpackEncoder writes each track as a MessagePack map, one after another
+// like NDJSON, as the number of tracks is not known when streaming begins
+type msgpackEncoder struct{}
+
+// ContentType implements trackEncoder
+func (msgpackEncoder) ContentType() string {
+	return "application/msgpack"
+}
+
+// Begin implements trackEncoder
+func (msgpackEncoder) Begin(buf *bytes.Buffer, template interface{}) error {
+	return nil
+}
+
+// Function to write the header of a MessagePack string, map or array
+// The fixed form is used when n fits in its bits, then 16 and 32 bit forms
+func writeMsgpackHeader(buf *bytes.Buffer, fixed byte, fixedMax int,
+	code16 byte, n int) {
+	switch {
+	case n <= fixedMax:
+		buf.WriteByte(fixed | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(code16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(code16 + 1)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+// Function to write a decoded value as MessagePack
+// Numbers without a fraction or exponent are written as integers
+func writeMsgpack(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case string:
+		if len(v) <= 31 {
+			buf.WriteByte(0xa0 | byte(len(v)))
+		} else if len(v) <= math.MaxUint8 {
+			buf.WriteByte(0xd9)
+			buf.WriteByte(byte(len(v)))
+		} else {
+			writeMsgpackHeader(buf, 0xa0, 31, 0xda, len(v))
+		}
+		buf.WriteString(v)
+	case json.Number:
+		if n, err := v.Int64(); err == nil && n >= 0 && n <= 0x7f {
+			buf.WriteByte(byte(n))
+			return
+		} else if err == nil {
+			buf.WriteByte(0xd3)
+			binary.Write(buf, binary.BigEndian, n)
+			return
+		}
+		f, _ := v.Float64()
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, f)
+	case []member:
+		writeMsgpackHeader(buf, 0x80, 15, 0xde, len(v))
+		for _, m := range v {
+			writeMsgpack(buf, m.name)
+			writeMsgpack(buf, m.value)
+		}
+	case []interface{}:
+		writeMsgpackHeader(buf, 0x90, 15, 0xdc, len(v))
+		for _, item := range v {
+			writeMsgpack(buf, item)
+		}
+	}
+}
+
+// Encode implements trackEncoder
+func (msgpackEncoder) Encode(buf *bytes.Buffer, track interface{},
+	index int) error {
+	value, err := orderedJSON(track)
+	if err != nil {
+		return err
+	}
+	writeMsgpack(buf, value)
+	return nil
+}
+
+// Fail implements trackEncoder, adding a map with the error after the
+// tracks
+func (msgpackEncoder) Fail(buf *bytes.Buffer, e apiError, index int) {
+	writeMsgpack(buf, errorMembers(e))
+}
+
+// End implements trackEncoder
+func (msgpackEncoder) End(buf *bytes.Buffer) {}
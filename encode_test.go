@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		url    string
+		accept string
+		want   string
+	}{
+		{"/", "", "json"},
+		{"/", "*/*", "json"},
+		{"/", "text/csv", "csv"},
+		{"/", "text/*", "json"},
+		{"/", "application/x-ndjson", "ndjson"},
+		{"/", "application/ndjson", "ndjson"},
+		{"/", "text/xml", "xml"},
+		{"/", "application/vnd.msgpack", "msgpack"},
+		{"/", "application/json;q=0.2, text/csv;q=0.5", "csv"},
+		{"/", "text/csv, */*;q=0.1", "csv"},
+		{"/", "text/csv;q=0.5, application/xml;q=0.5", "csv"},
+		// Another format needs the highest weight, JSON wins ties
+		{"/", "text/csv;q=0.9, application/json", "json"},
+		{"/", "text/csv, */*", "json"},
+		{"/", "text/csv;q=0, */*", "json"},
+		{"/", "text/*;q=0.9, text/csv;q=0.1, application/json;q=0.5", "json"},
+		// A browser, or a header naming nothing that can be produced
+		{"/", "text/html,application/xhtml+xml,application/xml;q=0.9," +
+			"image/webp,*/*;q=0.8", "json"},
+		{"/", "text/html", "json"},
+		{"/", "image/png", "json"},
+		{"/", "application/json;q=0, text/*;q=0", "json"},
+		{"/?format=NDJSON", "application/json", "ndjson"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.url, nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		format, err := negotiateFormat(req)
+		if err != nil || format.name != test.want {
+			t.Errorf("%s with Accept %q chose wrong format: \n\ngot\n\n%v %v"+
+				"\n\nwant\n\n%v", test.url, test.accept, format.name, err,
+				test.want)
+		}
+	}
+
+	for _, test := range []struct {
+		url    string
+		accept string
+		field  string
+	}{
+		{"/?format=yaml", "", "format"},
+		{"/?format=html", "text/html", "format"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, test.url, nil)
+		req.Header.Set("Accept", test.accept)
+		if _, err := negotiateFormat(req); err == nil ||
+			err.Code != "not_acceptable" || err.Field != test.field {
+			t.Errorf("%s with Accept %q gave wrong error: %v", test.url,
+				test.accept, err)
+		}
+	}
+}
+
+// Function to request tracks from the test server in a format
+func getFormat(t *testing.T, target string, accept string,
+	contentType string) []byte {
+	rec := getWithHeaders(testServer.routes(), target,
+		map[string]string{"Accept": accept})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s returned wrong status code: \n\ngot\n\n%v\n\nwant\n\n%v",
+			target, rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Type"); got != contentType {
+		t.Errorf("%s returned wrong content type: \n\ngot\n\n%v\n\nwant"+
+			"\n\n%v", target, got, contentType)
+	}
+	return rec.Body.Bytes()
+}
+
+func TestFormats(t *testing.T) {
+	const target = "/?search=jump&limit=2&fields=TrackId,Name,Composer" +
+		"&expand=genre"
+
+	rows, err := csv.NewReader(bytes.NewReader(getFormat(t, target,
+		"text/csv", "text/csv; charset=utf-8"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"TrackId", "Name", "Composer", "Genre.GenreId", "Genre.Name"},
+		{"3070", "Jump", "Edward Van Halen, Alex Van Halen, David Lee Roth",
+			"1", "Rock"},
+		{"3300", "Jump Around", "E. Schrody/L. Muggerud", "17",
+			"Hip Hop/Rap"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("wrong CSV: \n\ngot\n\n%v\n\nwant\n\n%v", rows, want)
+	}
+
+	// An empty result still has a header
+	rows, _ = csv.NewReader(bytes.NewReader(getFormat(t,
+		"/?search=zzzzqq&fields=Name&format=csv", "",
+		"text/csv; charset=utf-8"))).ReadAll()
+	if !reflect.DeepEqual(rows, [][]string{{"Name"}}) {
+		t.Errorf("wrong CSV for no tracks: %v", rows)
+	}
+
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(getFormat(t, target,
+		"application/x-ndjson", "application/x-ndjson")))
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("NDJSON line is not valid JSON: %v", err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 || lines[1]["Name"] != "Jump Around" {
+		t.Errorf("wrong NDJSON: %v", lines)
+	}
+
+	var doc struct {
+		Tracks []struct {
+			TrackId  int64
+			Name     string
+			Composer string
+			Genre    struct{ Name string }
+		} `xml:"Track"`
+	}
+	body := getFormat(t, target, "application/xml", "application/xml")
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		t.Fatalf("invalid XML: %v\n\n%s", err, body)
+	}
+	if len(doc.Tracks) != 2 || doc.Tracks[0].TrackId != 3070 ||
+		doc.Tracks[1].Genre.Name != "Hip Hop/Rap" {
+		t.Errorf("wrong XML: %+v", doc)
+	}
+
+	body = getFormat(t, "/tracks?limit=1&fields=TrackId,Composer&format=msgpack",
+		"", "application/msgpack")
+	wantBody := append([]byte{0x82, 0xa7}, "TrackId"...)
+	wantBody = append(append(wantBody, 0x01, 0xa8), "Composer"...)
+	wantBody = append(append(wantBody, 0xd9, 0x29),
+		"Angus Young, Malcolm Young, Brian Johnson"...)
+	if !bytes.Equal(body, wantBody) {
+		t.Errorf("wrong MessagePack: \n\ngot\n\n%x\n\nwant\n\n%x", body,
+			wantBody)
+	}
+}
+
+// Ensure a browser, which prefers HTML and XML to anything else, gets the
+// JSON it got before other formats were added
+func TestBrowserAccept(t *testing.T) {
+	const accept = "text/html,application/xhtml+xml,application/xml;q=0.9," +
+		"image/avif,image/webp,image/apng,*/*;q=0.8," +
+		"application/signed-exchange;v=b3;q=0.7"
+	for _, target := range []string{"/?search=jump", "/tracks?limit=2",
+		"/albums/1/tracks", "/genres"} {
+		var tracks []map[string]interface{}
+		body := getFormat(t, target, accept, "application/json")
+		if err := json.Unmarshal(body, &tracks); err != nil ||
+			len(tracks) == 0 {
+			t.Errorf("%s returned wrong body: %v\n\n%s", target, err, body)
+		}
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	tests := []struct {
+		url    string
+		accept string
+		status int
+		code   string
+		field  string
+	}{
+		{"/?search=jump&format=yaml", "", http.StatusNotAcceptable,
+			"not_acceptable", "format"},
+		{"/?search=jump&format=csv&envelope=true", "", http.StatusBadRequest,
+			"invalid_envelope", "envelope"},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, test.url, nil)
+		req.Header.Set("Accept", test.accept)
+		testServer.routes().ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s returned wrong status code: \n\ngot\n\n%v\n\nwant"+
+				"\n\n%v", test.url, rec.Code, test.status)
+		}
+		ResponseErrorTest(rec, test.code, test.field, t)
+	}
+}
+
+// Ensure a failure after streaming has begun leaves well formed XML
+func TestTrackStreamFailXML(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/?search=jump", nil)
+
+	stream := newEncodedTrackStream(rec, req, 0, xmlEncoder{}, nil)
+	if err := stream.Write(testTrack(1, "Jump")); err != nil {
+		t.Fatal(err)
+	}
+	stream.Fail(errDatabase)
+
+	var doc struct {
+		Tracks []struct{ Name string } `xml:"Track"`
+	}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("streamed response is not valid XML: %v\n\n%s", err,
+			rec.Body.String())
+	}
+	if len(doc.Tracks) != 1 {
+		t.Errorf("wrong tracks before the error: %+v", doc)
+	}
+	if !bytes.Contains(rec.Body.Bytes(), []byte("<code>database_error</code>")) {
+		t.Errorf("missing error element: %s", rec.Body.String())
+	}
+}
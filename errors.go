@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// apiError is the body sent to clients when a request fails
+// Code is a stable machine-readable identifier from the catalog below,
+// Message is a human-readable explanation and Field names the URL parameter
+// at fault, if any
+type apiError struct {
+	Status    int    `json:"-"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Field     string `json:"field,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Catalog of every error the server can return
+var (
+	errMethodNotAllowed = apiError{Status: http.StatusMethodNotAllowed,
+		Code: "method_not_allowed", Message: "Method not allowed"}
+	errNotFound = apiError{Status: http.StatusNotFound,
+		Code: "not_found", Message: "Not found"}
+	errNotAcceptable = apiError{Status: http.StatusNotAcceptable,
+		Code:    "not_acceptable",
+		Message: "The response cannot be given in an accepted format"}
+	errUnauthorized = apiError{Status: http.StatusUnauthorized,
+		Code: "unauthorized", Message: "The bearer token is not valid"}
+	errReadOnly = apiError{Status: http.StatusMethodNotAllowed,
+		Code: "read_only", Message: "The database is read-only"}
+	errInvalidBody = apiError{Status: http.StatusBadRequest,
+		Code: "invalid_body", Message: "Invalid request body"}
+	errInvalidTrack = apiError{Status: http.StatusUnprocessableEntity,
+		Code: "invalid_track", Message: "Invalid track"}
+	errTrackExists = apiError{Status: http.StatusConflict,
+		Code: "track_exists", Message: "Track already exists",
+		Field: "TrackId"}
+	errTrackInUse = apiError{Status: http.StatusConflict,
+		Code:    "track_in_use",
+		Message: "Track is referenced by invoices or playlists"}
+	errPlaylistTrackExists = apiError{Status: http.StatusConflict,
+		Code:    "playlist_track_exists",
+		Message: "Track is already on the playlist"}
+	errInvalidDate = apiError{Status: http.StatusBadRequest,
+		Code:    "invalid_date",
+		Message: "Dates must be in the form YYYY-MM-DD"}
+	errInvalidGroupBy = apiError{Status: http.StatusBadRequest,
+		Code: "invalid_group_by", Message: "Unknown grouping",
+		Field: "group_by"}
+	errMissingSearch = apiError{Status: http.StatusBadRequest,
+		Code: "missing_search", Message: "The search parameter is required",
+		Field: "search"}
+	errEmptySearch = apiError{Status: http.StatusBadRequest,
+		Code: "empty_search", Message: "No valid search criteria",
+		Field: "search"}
+	errSearchTooLong = apiError{Status: http.StatusBadRequest,
+		Code: "search_too_long", Message: "The search is too long",
+		Field: "search"}
+	errInvalidMode = apiError{Status: http.StatusBadRequest,
+		Code: "invalid_mode", Message: "Unknown search mode", Field: "mode"}
+	errFullTextUnavailable = apiError{Status: http.StatusNotImplemented,
+		Code:    "fulltext_unavailable",
+		Message: "Full-text search is not available on this server",
+		Field:   "mode"}
+	errInvalidFuzzy = apiError{Status: http.StatusBadRequest,
+		Code: "invalid_fuzzy", Message: "Fuzzy must be true or false",
+		Field: "fuzzy"}
+	errInvalidSearchFields = apiError{Status: http.StatusBadRequest,
+		Code: "invalid_search_fields", Message: "Unknown search field",
+		Field: "search_fields"}
+	errInvalidLimit = apiError{Status: http.StatusBadRequest,
+		Code: "invalid_limit", Message: "Limit must be an integer",
+		Field: "limit"}
+	errInvalidOffset = apiError{Status: http.StatusBadRequest,
+		Code: "invalid_offset", Message: "Offset must be an integer",
+		Field: "offset"}
+	errInvalidEnvelope = apiError{Status: http.StatusBadRequest,
+		Code: "invalid_envelope", Message: "Envelope must be true or false",
+		Field: "envelope"}
+	errInvalidPretty = apiError{Status: http.StatusBadRequest,
+		Code: "invalid_pretty", Message: "Pretty must be true or false",
+		Field: "pretty"}
+	errInvalidFilter = apiError{Status: http.StatusBadRequest,
+		Code: "invalid_filter", Message: "Invalid filter"}
+	errInvalidFields = apiError{Status: http.StatusBadRequest,
+		Code: "invalid_fields", Message: "Invalid fields", Field: "fields"}
+	errInvalidExpand = apiError{Status: http.StatusBadRequest,
+		Code: "invalid_expand", Message: "Invalid expand", Field: "expand"}
+	errInvalidSort = apiError{Status: http.StatusBadRequest,
+		Code: "invalid_sort", Message: "Invalid sort order", Field: "sort"}
+	errCursorWithSort = apiError{Status: http.StatusBadRequest,
+		Code:    "cursor_with_sort",
+		Message: "Cursors can only be used with the default sort order",
+		Field:   "sort"}
+	errInvalidCursor = apiError{Status: http.StatusBadRequest,
+		Code:    "invalid_cursor",
+		Message: "Cursor is invalid or was issued for a different search",
+		Field:   "cursor"}
+	errCursorWithOffset = apiError{Status: http.StatusBadRequest,
+		Code:    "cursor_with_offset",
+		Message: "Cursor and offset cannot be used together",
+		Field:   "offset"}
+	errDatabase = apiError{Status: http.StatusInternalServerError,
+		Code: "database_error", Message: "Database error"}
+	errQueryTimeout = apiError{Status: http.StatusServiceUnavailable,
+		Code: "query_timeout", Message: "The database query took too long"}
+	errServer = apiError{Status: http.StatusInternalServerError,
+		Code: "server_error", Message: "Server error"}
+	errEncoding = apiError{Status: http.StatusInternalServerError,
+		Code: "encoding_error", Message: "Encoding error"}
+)
+
+// Error implements the error interface
+func (e apiError) Error() string {
+	return fmt.Sprintf("%d Error: %s", e.Status, e.Message)
+}
+
+// withMessage returns a copy of e with a more specific message
+func (e apiError) withMessage(format string, a ...interface{}) apiError {
+	e.Message = fmt.Sprintf(format, a...)
+	return e
+}
+
+// Function to send a JSON error response and print the error to the log
+func errorHandler(w http.ResponseWriter, r *http.Request, e apiError) {
+	e.RequestID = requestID(r)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	body, _ := json.Marshal(struct {
+		Error apiError `json:"error"`
+	}{e})
+	w.Write(body)
+
+	message := e.Error()
+	if e.RequestID != "" {
+		message += " (request " + e.RequestID + ")"
+	}
+	if e.Status >= http.StatusInternalServerError {
+		logAt(levelError, message)
+	} else {
+		logAt(levelWarn, message)
+	}
+}
+
+// Function to send a 405 response listing the methods the route accepts
+func methodNotAllowed(w http.ResponseWriter, r *http.Request,
+	allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	errorHandler(w, r, errMethodNotAllowed.withMessage(
+		"Method %s not allowed, use %s", r.Method,
+		strings.Join(allowed, " or ")))
+}
+
+// Function to choose the error to report for a failed database call
+func databaseError(err error) apiError {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errQueryTimeout
+	}
+	return errDatabase
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"strings"
+)
+
+// Genre is a row of the Genre table
+type Genre struct {
+	GenreId NullInt64  `json:"GenreId"`
+	Name    NullString `json:"Name"`
+}
+
+// MediaType is a row of the MediaType table
+type MediaType struct {
+	MediaTypeId NullInt64  `json:"MediaTypeId"`
+	Name        NullString `json:"Name"`
+}
+
+// Album is a row of the Album table
+type Album struct {
+	AlbumId  NullInt64  `json:"AlbumId"`
+	Title    NullString `json:"Title"`
+	ArtistId NullInt64  `json:"ArtistId"`
+}
+
+// Artist is a row of the Artist table
+type Artist struct {
+	ArtistId NullInt64  `json:"ArtistId"`
+	Name     NullString `json:"Name"`
+}
+
+// trackExpansion is a related entity that can be embedded in each track
+// with the expand parameter
+// The object is written under key, in place of a flat field with the same
+// name if there is one. The fields in scan are read to build it, joining
+// the related table with join if it is not already joined
+type trackExpansion struct {
+	name  string
+	key   string
+	join  string
+	scan  []string
+	value func(t *Track) interface{}
+}
+
+// Fields only read to build expanded objects, never written themselves
+var expansionFields = []trackField{
+	{"GenreName", "genre.Name", func(t *Track) interface{} { return &t.GenreName }},
+	{"MediaTypeName", "mediatype.Name",
+		func(t *Track) interface{} { return &t.MediaTypeName }},
+	{"ArtistId", "album.ArtistId",
+		func(t *Track) interface{} { return &t.ArtistId }},
+	{"SalesQuantity", trackSalesQuantity,
+		func(t *Track) interface{} { return &t.SalesQuantity }},
+	{"SalesRevenue", trackSalesRevenue,
+		func(t *Track) interface{} { return &t.SalesRevenue }},
+}
+
+// Every entity that can be expanded
+var trackExpansions = []trackExpansion{
+	{"genre", "Genre", "LEFT JOIN genre ON track.GenreId = genre.GenreId",
+		[]string{"GenreId", "GenreName"},
+		func(t *Track) interface{} { return &Genre{t.GenreId, t.GenreName} }},
+	{"media_type", "MediaType",
+		"LEFT JOIN mediatype ON track.MediaTypeId = mediatype.MediaTypeId",
+		[]string{"MediaTypeId", "MediaTypeName"},
+		func(t *Track) interface{} {
+			return &MediaType{t.MediaTypeId, t.MediaTypeName}
+		}},
+	{"album", "Album", "", []string{"AlbumId", "Album", "ArtistId"},
+		func(t *Track) interface{} {
+			return &Album{t.AlbumId, t.Album, t.ArtistId}
+		}},
+	{"artist", "Artist", "", []string{"ArtistId", "Artist"},
+		func(t *Track) interface{} { return &Artist{t.ArtistId, t.Artist} }},
+	{"sales", "Sales", "", []string{"SalesQuantity", "SalesRevenue"},
+		func(t *Track) interface{} {
+			return &Sales{t.SalesQuantity.Int64, t.SalesRevenue.Float64}
+		}},
+}
+
+// Function to list the names of the expansions, for messages
+func trackExpansionNames() string {
+	names := make([]string, len(trackExpansions))
+	for i, x := range trackExpansions {
+		names[i] = x.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// Function to parse an expand= value such as "genre,album"
+// Names are matched ignoring case and the expansions are returned in
+// trackExpansions order. The sales expansion is always all-time, the from
+// and to dates of the reports do not apply to tracks
+func parseExpand(value string) ([]trackExpansion, *apiError) {
+	wanted := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		found := false
+		for _, x := range trackExpansions {
+			if x.name == name {
+				wanted[x.name], found = true, true
+				break
+			}
+		}
+		if !found {
+			e := errInvalidExpand.withMessage(
+				"Unknown expansion %q, must be one of %s", name,
+				trackExpansionNames())
+			return nil, &e
+		}
+	}
+
+	var expansions []trackExpansion
+	for _, x := range trackExpansions {
+		if wanted[x.name] {
+			expansions = append(expansions, x)
+		}
+	}
+	return expansions, nil
+}
+
+// Function to find a field read from the database by name, including the
+// fields only read for expansions
+func scanField(name string) trackField {
+	for _, f := range trackFields {
+		if f.name == name {
+			return f
+		}
+	}
+	for _, f := range expansionFields {
+		if f.name == name {
+			return f
+		}
+	}
+	panic("unknown track field " + name)
+}
+
+// Function to add the fields read for expansions to the fields read from
+// the database
+func expandScanFields(scan []trackField,
+	expansions []trackExpansion) []trackField {
+	for _, x := range expansions {
+		for _, name := range x.scan {
+			if !containsField(scan, name) {
+				scan = append(scan, scanField(name))
+			}
+		}
+	}
+	return scan
+}
+
+// Function to add the joins needed by expansions to a query
+func applyExpansions(q *queryBuilder, expansions []trackExpansion) *queryBuilder {
+	for _, x := range expansions {
+		if x.join != "" {
+			q.Join(x.join)
+		}
+	}
+	return q
+}
+
+// Function to get the fields written for each track, or nil to write
+// the whole Track
+// Expanded objects replace the flat field of the same name or follow the
+// other fields
+func viewFields(p searchParams) []trackField {
+	if p.Fields == nil && p.Expand == nil {
+		return nil
+	}
+	fields := p.Fields
+	if fields == nil {
+		for _, f := range trackFields {
+			if f.column != "" || p.Mode == modeFuzzy {
+				fields = append(fields, f)
+			}
+		}
+	}
+
+	view := append([]trackField(nil), fields...)
+	for _, x := range p.Expand {
+		expanded := trackField{name: x.key, ref: x.value}
+		replaced := false
+		for i, f := range view {
+			if f.name == x.key {
+				view[i], replaced = expanded, true
+			}
+		}
+		if !replaced {
+			view = append(view, expanded)
+		}
+	}
+	return view
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		url  string
+		want map[string]interface{}
+	}{
+		{"/tracks?limit=1&fields=TrackId,GenreId&expand=genre,media_type",
+			map[string]interface{}{
+				"TrackId":   1.0,
+				"GenreId":   1.0,
+				"Genre":     map[string]interface{}{"GenreId": 1.0, "Name": "Rock"},
+				"MediaType": map[string]interface{}{"MediaTypeId": 1.0, "Name": "MPEG audio file"},
+			}},
+		{"/tracks?limit=1&fields=Name,Artist&expand=ARTIST,album",
+			map[string]interface{}{
+				"Name":   "For Those About To Rock (We Salute You)",
+				"Artist": map[string]interface{}{"ArtistId": 1.0, "Name": "AC/DC"},
+				"Album": map[string]interface{}{"AlbumId": 1.0,
+					"Title": "For Those About To Rock We Salute You", "ArtistId": 1.0},
+			}},
+		{"/?search=jump&limit=1&fields=TrackId&expand=genre",
+			map[string]interface{}{
+				"TrackId": 3070.0,
+				"Genre":   map[string]interface{}{"GenreId": 1.0, "Name": "Rock"},
+			}},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		testServer.routes().ServeHTTP(rec,
+			httptest.NewRequest(http.MethodGet, test.url, nil))
+		var tracks []map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &tracks); err != nil {
+			t.Fatal(err)
+		}
+		if len(tracks) != 1 || !reflect.DeepEqual(tracks[0], test.want) {
+			t.Errorf("%s returned wrong tracks: \n\ngot\n\n%v\n\nwant\n\n%v",
+				test.url, tracks, test.want)
+		}
+	}
+
+	// Without expand the flat shape is unchanged
+	rec := httptest.NewRecorder()
+	testServer.routes().ServeHTTP(rec,
+		httptest.NewRequest(http.MethodGet, "/tracks?limit=1", nil))
+	var tracks []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &tracks); err != nil {
+		t.Fatal(err)
+	}
+	if artist, _ := tracks[0]["Artist"].(string); artist != "AC/DC" {
+		t.Errorf("flat track has wrong artist: %v", tracks[0]["Artist"])
+	}
+	if _, ok := tracks[0]["Genre"]; ok {
+		t.Errorf("flat track has an expanded genre: %v", tracks[0])
+	}
+
+	rec = httptest.NewRecorder()
+	testServer.routes().ServeHTTP(rec,
+		httptest.NewRequest(http.MethodGet, "/tracks?expand=genre,invoice", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("wrong status code: \n\ngot\n\n%v\n\nwant\n\n%v",
+			rec.Code, http.StatusBadRequest)
+	}
+	ResponseErrorTest(rec, "invalid_expand", "expand", t)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// trackField is a Track field that can be chosen with the fields parameter
+// The column is selected into the field, which is found with ref. Fields
+// with no column are computed by the search mode
+type trackField struct {
+	name   string
+	column string
+	ref    func(t *Track) interface{}
+}
+
+// Every field of a Track in the order they are written
+var trackFields = []trackField{
+	{"TrackId", "track.TrackId", func(t *Track) interface{} { return &t.TrackId }},
+	{"Name", "track.Name", func(t *Track) interface{} { return &t.Name }},
+	{"Artist", "artist.Name", func(t *Track) interface{} { return &t.Artist }},
+	{"Album", "album.Title", func(t *Track) interface{} { return &t.Album }},
+	{"AlbumId", "track.AlbumId", func(t *Track) interface{} { return &t.AlbumId }},
+	{"MediaTypeId", "track.MediaTypeId",
+		func(t *Track) interface{} { return &t.MediaTypeId }},
+	{"GenreId", "track.GenreId", func(t *Track) interface{} { return &t.GenreId }},
+	{"Composer", "track.Composer",
+		func(t *Track) interface{} { return &t.Composer }},
+	{"Milliseconds", "track.Milliseconds",
+		func(t *Track) interface{} { return &t.Milliseconds }},
+	{"Bytes", "track.Bytes", func(t *Track) interface{} { return &t.Bytes }},
+	{"UnitPrice", "track.UnitPrice",
+		func(t *Track) interface{} { return &t.UnitPrice }},
+	{"Score", "", func(t *Track) interface{} { return &t.Score }},
+}
+
+// Fields always read from the database, as cursors are built from them
+var keyFields = []string{"TrackId", "Name"}
+
+// Function to list the names of the fields, for messages
+func trackFieldNames() string {
+	names := make([]string, len(trackFields))
+	for i, f := range trackFields {
+		names[i] = f.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// Function to parse a fields= value such as "TrackId,Name,Artist"
+// Names are matched ignoring case and the fields are returned in Track
+// order. Score is only available to fuzzy searches
+func parseFields(value string, mode string) ([]trackField, *apiError) {
+	wanted := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, f := range trackFields {
+			if strings.EqualFold(f.name, name) {
+				wanted[f.name], found = true, true
+				break
+			}
+		}
+		if !found {
+			e := errInvalidFields.withMessage(
+				"Unknown field %q, must be one of %s", name, trackFieldNames())
+			return nil, &e
+		}
+	}
+	if wanted["Score"] && mode != modeFuzzy {
+		e := errInvalidFields.withMessage(
+			"The Score field is only available with fuzzy=true")
+		return nil, &e
+	}
+
+	var fields []trackField
+	for _, f := range trackFields {
+		if wanted[f.name] {
+			fields = append(fields, f)
+		}
+	}
+	return fields, nil
+}
+
+// Function to get the fields read from the database for a set of chosen
+// fields, which adds the key fields and drops computed fields
+// Every field with a column is read when none are chosen
+func scanFields(chosen []trackField) []trackField {
+	var fields []trackField
+	for _, f := range trackFields {
+		if f.column == "" {
+			continue
+		}
+		if chosen == nil || containsField(chosen, f.name) ||
+			containsString(keyFields, f.name) {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// Function to check whether a list of fields contains a field
+func containsField(fields []trackField, name string) bool {
+	for _, f := range fields {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Function to get the columns selected for a list of fields
+func fieldColumns(fields []trackField) []string {
+	columns := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if f.column != "" {
+			columns = append(columns, f.column)
+		}
+	}
+	return columns
+}
+
+// Function to get the scan destinations in a track for a list of fields
+func fieldRefs(t *Track, fields []trackField) []interface{} {
+	refs := make([]interface{}, len(fields))
+	for i, f := range fields {
+		refs[i] = f.ref(t)
+	}
+	return refs
+}
+
+// trackView writes only the chosen fields of a track as JSON
+type trackView struct {
+	track  *Track
+	fields []trackField
+}
+
+// MarshalJSON implements json.Marshaler, keeping the order of the fields
+func (v trackView) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range v.fields {
+		value, err := json.Marshal(f.ref(v.track))
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(`"` + f.name + `":`)
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseFields(t *testing.T) {
+	fields, err := parseFields("artist, name,TRACKID,Name", modeSubstring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range fields {
+		names = append(names, f.name)
+	}
+	if got := strings.Join(names, ","); got != "TrackId,Name,Artist" {
+		t.Errorf("wrong fields: \n\ngot\n\n%v\n\nwant\n\n%v", got,
+			"TrackId,Name,Artist")
+	}
+
+	// Only the chosen columns and the key columns are selected
+	query, _ := newFieldQuery(scanFields(fields[2:])).Build()
+	want := "SELECT track.TrackId, track.Name, artist.Name " + trackFrom
+	if query != want {
+		t.Errorf("wrong query: \n\ngot\n\n%v\n\nwant\n\n%v", query, want)
+	}
+
+	bad := []struct {
+		value string
+		mode  string
+	}{
+		{"TrackId,Genre", modeSubstring},
+		{"TrackId,", modeSubstring},
+		{"track.Name", modeSubstring},
+		{"Score", modeBrowse},
+	}
+	for _, test := range bad {
+		if _, err := parseFields(test.value, test.mode); err == nil {
+			t.Errorf("parseFields(%q, %q): expected error, got nil",
+				test.value, test.mode)
+		}
+	}
+}
+
+func TestSparseFieldsets(t *testing.T) {
+	rec := httptest.NewRecorder()
+	testServer.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+		"/?search=jump&limit=2&fields=Artist,UnitPrice", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status code: \n\ngot\n\n%v\n\nwant\n\n%v",
+			rec.Code, http.StatusOK)
+	}
+	var tracks []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &tracks); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"Artist": "Van Halen", "UnitPrice": 0.99}
+	if len(tracks) != 2 || len(tracks[0]) != 2 ||
+		tracks[0]["Artist"] != want["Artist"] ||
+		tracks[0]["UnitPrice"] != want["UnitPrice"] {
+		t.Errorf("wrong tracks: \n\ngot\n\n%v\n\nwant first\n\n%v", tracks, want)
+	}
+	// The key fields are still read, so cursors work without them
+	if rec.Header().Get("X-Next-Cursor") == "" {
+		t.Errorf("trimmed search has no next cursor")
+	}
+
+	rec = httptest.NewRecorder()
+	testServer.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+		"/tracks?fields=Name,Genre", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("wrong status code: \n\ngot\n\n%v\n\nwant\n\n%v",
+			rec.Code, http.StatusBadRequest)
+	}
+	ResponseErrorTest(rec, "invalid_fields", "fields", t)
+}
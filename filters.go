@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+)
+
+// filter describes a URL parameter that narrows a track search
+// The clause is added to the query with the parsed value bound to its
+// single ? placeholder, so filters combine with AND
+type filter struct {
+	name   string
+	clause string
+	parse  func(value string) (interface{}, error)
+}
+
+// filterValue is a filter clause with its parsed value, ready to add to
+// a query
+type filterValue struct {
+	clause string
+	arg    interface{}
+}
+
+// Function to parse a name matched exactly, ignoring the case of ASCII
+// letters
+func textFilter(value string) (interface{}, error) {
+	return value, nil
+}
+
+// Function to parse text matched anywhere in a column with LIKE
+func containsFilter(value string) (interface{}, error) {
+	return "%" + escapeLike(value) + "%", nil
+}
+
+// Function to parse a row id, which must be a positive integer
+func idFilter(value string) (interface{}, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("must be a positive integer, got %q", value)
+	}
+	return n, nil
+}
+
+// Function to parse a duration in milliseconds, which must not be negative
+func millisecondsFilter(value string) (interface{}, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("must be zero or a positive integer, got %q",
+			value)
+	}
+	return n, nil
+}
+
+// Function to parse a price, which must not be negative
+func priceFilter(value string) (interface{}, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("must be zero or a positive number, got %q",
+			value)
+	}
+	return f, nil
+}
+
+// Every filter that can be given on a track search
+// Names are matched against the related table so no join is needed
+var filters = []filter{
+	{"genre", "track.GenreId IN " +
+		"(SELECT GenreId FROM genre WHERE Name = ? COLLATE NOCASE)", textFilter},
+	{"genre_id", "track.GenreId = ?", idFilter},
+	{"media_type", "track.MediaTypeId IN " +
+		"(SELECT MediaTypeId FROM mediatype WHERE Name = ? COLLATE NOCASE)",
+		textFilter},
+	{"media_type_id", "track.MediaTypeId = ?", idFilter},
+	{"album", "album.Title = ? COLLATE NOCASE", textFilter},
+	{"album_id", "track.AlbumId = ?", idFilter},
+	{"artist", "artist.Name = ? COLLATE NOCASE", textFilter},
+	{"artist_id", "album.ArtistId = ?", idFilter},
+	{"composer", `track.Composer LIKE ? ESCAPE '\'`, containsFilter},
+	{"min_ms", "track.Milliseconds >= ?", millisecondsFilter},
+	{"max_ms", "track.Milliseconds <= ?", millisecondsFilter},
+	{"min_price", "track.UnitPrice >= ?", priceFilter},
+	{"max_price", "track.UnitPrice <= ?", priceFilter},
+}
+
+// Ranges given as a pair of minimum and maximum filters
+var filterRanges = [][2]string{{"min_ms", "max_ms"}, {"min_price", "max_price"}}
+
+// Function to read and validate the filters in the URL parameters
+// A filter given with an empty value is treated as missing
+func parseFilters(values url.Values) ([]filterValue, *apiError) {
+	var parsed []filterValue
+	byName := make(map[string]interface{})
+	for _, f := range filters {
+		value := values.Get(f.name)
+		if value == "" {
+			continue
+		}
+		arg, err := f.parse(value)
+		if err != nil {
+			e := errInvalidFilter.withMessage("%s %s", f.name, err)
+			e.Field = f.name
+			return nil, &e
+		}
+		parsed = append(parsed, filterValue{f.clause, arg})
+		byName[f.name] = arg
+	}
+
+	// The minimum of a range may not be more than its maximum
+	for _, r := range filterRanges {
+		low, hasLow := byName[r[0]]
+		high, hasHigh := byName[r[1]]
+		if hasLow && hasHigh && filterGreater(low, high) {
+			e := errInvalidFilter.withMessage(
+				"%s must not be greater than %s", r[0], r[1])
+			e.Field = r[0]
+			return nil, &e
+		}
+	}
+	return parsed, nil
+}
+
+// Function to check whether one parsed numeric filter value is greater
+// than another of the same type
+func filterGreater(a interface{}, b interface{}) bool {
+	switch a := a.(type) {
+	case int64:
+		return a > b.(int64)
+	case float64:
+		return a > b.(float64)
+	}
+	return false
+}
+
+// Function to add parsed filters to a query
+func applyFilters(q *queryBuilder, parsed []filterValue) *queryBuilder {
+	for _, f := range parsed {
+		q.Where(f.clause, f.arg)
+	}
+	return q
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFilters(t *testing.T) {
+	tests := []struct {
+		url  string
+		want int
+	}{
+		{"/?search=love&genre=rock&limit=1000", 64},
+		{"/?search=love&genre_id=1&limit=1000", 64},
+		{"/?search=a&artist=ac/dc&min_ms=300000", 2},
+		{"/?search=a&min_price=1.5&limit=1000", 143},
+		{"/?search=a&composer=kilmister&media_type=MPEG+audio+file", 12},
+		{"/?search=a&composer=kilmister&media_type_id=1", 12},
+		{"/?search=love&min_ms=200000&max_ms=250000", 33},
+		{"/?search=love&genre=rock&genre_id=2", 0},
+		{"/?search=love&genre=polka", 0},
+		{"/?search=love&genre=", 100},
+	}
+
+	for _, test := range tests {
+		if names := searchNames(t, testServer, test.url); len(names) != test.want {
+			t.Errorf("%s returned %d tracks, want %d", test.url, len(names),
+				test.want)
+		}
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	tests := []struct {
+		url   string
+		field string
+	}{
+		{"/?search=a&genre_id=0", "genre_id"},
+		{"/?search=a&album_id=x", "album_id"},
+		{"/?search=a&min_ms=-1", "min_ms"},
+		{"/?search=a&max_price=NaN", "max_price"},
+		{"/?search=a&min_ms=5&max_ms=4", "min_ms"},
+		{"/?search=a&min_price=2&max_price=1.99", "min_price"},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		testServer.routes().ServeHTTP(rec,
+			httptest.NewRequest(http.MethodGet, test.url, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s returned wrong status code: \n\ngot\n\n%v"+
+				"\n\nwant\n\n%v", test.url, rec.Code, http.StatusBadRequest)
+		}
+		ResponseErrorTest(rec, "invalid_filter", test.field, t)
+	}
+}
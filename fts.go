@@ -0,0 +1,212 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// The full-text index is an FTS5 table kept in a shared in-memory database,
+// so it can be built even when the Chinook database is opened read-only.
+// Every connection to the Chinook database attaches it under ftsSchema
+// when it is opened, see connectHook in store.go.
+// FTS5 is only compiled into go-sqlite3 with the sqlite_fts5 build tag, so
+// without it the index is unavailable and full-text searches are refused.
+
+// Schema name the full-text index is attached under
+const ftsSchema = "search"
+
+// Columns of the full-text index that can be searched, in table order
+var ftsColumns = []string{"name", "album", "artist", "composer"}
+
+// BM25 weight of each column in ftsColumns, a match in the track name
+// counts for more than a match in the composer
+const ftsWeights = "10.0, 4.0, 6.0, 1.0"
+
+// BM25 rank of a track in a full-text search, lower is a better match
+const ftsRank = "bm25(track_fts, " + ftsWeights + ")"
+
+// Statement creating the full-text index
+// Diacritics are removed so "Motorhead" matches "Motörhead"
+const ftsCreate = "CREATE VIRTUAL TABLE IF NOT EXISTS track_fts USING fts5(" +
+	"name, album, artist, composer, " +
+	"tokenize = 'unicode61 remove_diacritics 2')"
+
+// Statement copying every track into the index from the Chinook database
+// attached as chinook
+const ftsPopulate = "INSERT INTO track_fts (rowid, name, album, artist, composer) " +
+	"SELECT track.TrackId, track.Name, album.Title, artist.Name, track.Composer " +
+	"FROM chinook.track " +
+	"LEFT JOIN chinook.album ON track.AlbumId = album.AlbumId " +
+	"LEFT JOIN chinook.artist ON album.ArtistId = artist.ArtistId"
+
+// Values of a track row for the index, used by the triggers below
+const ftsTrackValues = "new.TrackId, new.Name, " +
+	"(SELECT Title FROM main.Album WHERE AlbumId = new.AlbumId), " +
+	"(SELECT artist.Name FROM main.Album album " +
+	"INNER JOIN main.Artist artist ON album.ArtistId = artist.ArtistId " +
+	"WHERE album.AlbumId = new.AlbumId), " +
+	"new.Composer"
+
+// TEMP triggers keeping the index in sync with writes made through a
+// connection. TEMP triggers are the only kind allowed to modify a table in
+// another database
+var ftsTriggers = []string{
+	"CREATE TEMP TRIGGER IF NOT EXISTS track_fts_insert " +
+		"AFTER INSERT ON main.Track BEGIN " +
+		"INSERT INTO track_fts (rowid, name, album, artist, composer) " +
+		"VALUES (" + ftsTrackValues + "); END",
+	"CREATE TEMP TRIGGER IF NOT EXISTS track_fts_update " +
+		"AFTER UPDATE ON main.Track BEGIN " +
+		"DELETE FROM track_fts WHERE rowid = old.TrackId; " +
+		"INSERT INTO track_fts (rowid, name, album, artist, composer) " +
+		"VALUES (" + ftsTrackValues + "); END",
+	"CREATE TEMP TRIGGER IF NOT EXISTS track_fts_delete " +
+		"AFTER DELETE ON main.Track BEGIN " +
+		"DELETE FROM track_fts WHERE rowid = old.TrackId; END",
+	"CREATE TEMP TRIGGER IF NOT EXISTS track_fts_album " +
+		"AFTER UPDATE OF Title, ArtistId ON main.Album BEGIN " +
+		"UPDATE track_fts SET album = new.Title, " +
+		"artist = (SELECT Name FROM main.Artist WHERE ArtistId = new.ArtistId) " +
+		"WHERE rowid IN (SELECT TrackId FROM main.Track " +
+		"WHERE AlbumId = new.AlbumId); END",
+	"CREATE TEMP TRIGGER IF NOT EXISTS track_fts_artist " +
+		"AFTER UPDATE OF Name ON main.Artist BEGIN " +
+		"UPDATE track_fts SET artist = new.Name " +
+		"WHERE rowid IN (SELECT track.TrackId FROM main.Track track " +
+		"INNER JOIN main.Album album ON track.AlbumId = album.AlbumId " +
+		"WHERE album.ArtistId = new.ArtistId); END",
+}
+
+// Function to build the full-text index for the database at dbPath
+// The returned handle keeps the in-memory database alive and must stay open
+// for as long as connections attach it
+func openSearchIndex(dbPath string) (*sql.DB, string, error) {
+	uri := "file:chinook_search_" + newRequestID() +
+		"?mode=memory&cache=shared"
+	index, err := sql.Open("sqlite3", uri)
+	if err != nil {
+		return nil, "", err
+	}
+	index.SetMaxOpenConns(1)
+
+	steps := []struct {
+		query string
+		args  []interface{}
+	}{
+		{ftsCreate, nil},
+		{"ATTACH DATABASE ? AS chinook", []interface{}{"file:" + dbPath + "?mode=ro"}},
+		{ftsPopulate, nil},
+		{"DETACH DATABASE chinook", nil},
+	}
+	for _, step := range steps {
+		if _, err := index.Exec(step.query, step.args...); err != nil {
+			index.Close()
+			return nil, "", err
+		}
+	}
+	return index, uri, nil
+}
+
+// Function to attach the full-text index at indexURI to a new connection
+// to the Chinook database
+func attachSearchIndex(conn *sqlite3.SQLiteConn, indexURI string,
+	readOnly bool) error {
+	_, err := conn.Exec("ATTACH DATABASE ? AS "+ftsSchema,
+		[]driver.Value{indexURI})
+	if err != nil {
+		return fmt.Errorf("attaching search index: %w", err)
+	}
+	// Readers of the shared cache do not block writes to the index
+	if _, err := conn.Exec("PRAGMA read_uncommitted = true", nil); err != nil {
+		return err
+	}
+	if readOnly {
+		return nil
+	}
+	for _, trigger := range ftsTriggers {
+		if _, err := conn.Exec(trigger, nil); err != nil {
+			return fmt.Errorf("creating search index trigger: %w", err)
+		}
+	}
+	return nil
+}
+
+// Function to quote a term for an FTS5 query so it is matched as plain text
+// rather than read as query syntax
+func ftsQuote(term string) string {
+	return `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+}
+
+// Function to convert user search text into an FTS5 query
+// Words must all match, text in double quotes is matched as a phrase and a
+// trailing * on a word or phrase matches it as a prefix. Everything else is
+// quoted, so operators like OR and NEAR or column filters cannot be injected.
+// An empty string is returned when the text has no terms
+func ftsQuery(text string, columns []string) string {
+	var terms []string
+	addTerm := func(term string) {
+		prefix := strings.HasSuffix(term, "*")
+		term = strings.TrimRight(term, "*")
+		if strings.TrimSpace(term) == "" {
+			return
+		}
+		quoted := ftsQuote(term)
+		if prefix {
+			quoted += "*"
+		}
+		terms = append(terms, quoted)
+	}
+
+	for len(text) > 0 {
+		text = strings.TrimLeft(text, " \t\n")
+		if text == "" {
+			break
+		}
+		if text[0] == '"' {
+			// Phrase, up to the closing quote or the end of the text
+			end := strings.IndexByte(text[1:], '"')
+			if end < 0 {
+				addTerm(text[1:])
+				break
+			}
+			phrase := text[1 : end+1]
+			text = text[end+2:]
+			if strings.HasPrefix(text, "*") {
+				phrase += "*"
+				text = strings.TrimLeft(text, "*")
+			}
+			addTerm(phrase)
+			continue
+		}
+		end := strings.IndexAny(text, " \t\n\"")
+		if end < 0 {
+			end = len(text)
+		}
+		addTerm(text[:end])
+		text = text[end:]
+	}
+
+	if len(terms) == 0 {
+		return ""
+	}
+	query := strings.Join(terms, " ")
+	if len(columns) > 0 && len(columns) < len(ftsColumns) {
+		query = "{" + strings.Join(columns, " ") + "} : (" + query + ")"
+	}
+	return query
+}
+
+// Function to add a full-text match and BM25 ordering to a query
+// TrackId breaks ties between equally ranked tracks
+func searchFullText(q *queryBuilder, match string) *queryBuilder {
+	q.Join("INNER JOIN " + ftsSchema + ".track_fts ON " +
+		"track_fts.rowid = track.TrackId")
+	q.Where("track_fts MATCH ?", match)
+	q.OrderBy(ftsRank)
+	q.OrderBy("track.TrackId")
+	return q
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFTSQuery(t *testing.T) {
+	tests := []struct {
+		text    string
+		columns []string
+		want    string
+	}{
+		{"jesus of suburbia", nil, `"jesus" "of" "suburbia"`},
+		{`"jesus of" suburb*`, nil, `"jesus of" "suburb"*`},
+		{`"jesus of"* green`, nil, `"jesus of"* "green"`},
+		{`london OR NEAR(a b)`, nil, `"london" "OR" "NEAR(a" "b)"`},
+		{`name:jump`, []string{"name", "artist"},
+			`{name artist} : ("name:jump")`},
+		{`"unterminated phrase`, nil, `"unterminated phrase"`},
+		{`say "" *`, nil, `"say"`},
+		{"   ", nil, ""},
+	}
+
+	for _, test := range tests {
+		if got := ftsQuery(test.text, test.columns); got != test.want {
+			t.Errorf("ftsQuery(%q, %v): \n\ngot\n\n%v\n\nwant\n\n%v",
+				test.text, test.columns, got, test.want)
+		}
+	}
+}
+
+// Function to run a search and return the names of the tracks found
+func searchNames(t *testing.T, server *Server, target string) []string {
+	rec := httptest.NewRecorder()
+	server.routes().ServeHTTP(rec,
+		httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s returned wrong status code: \n\ngot\n\n%v\n\nwant\n\n%v"+
+			"\n\n%s", target, rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	var tracks []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &tracks); err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(tracks))
+	for i, track := range tracks {
+		names[i], _ = track["Name"].(string)
+	}
+	return names
+}
+
+func TestFullTextSearch(t *testing.T) {
+	if !testServer.store.FullText() {
+		t.Skip("full-text search needs the sqlite_fts5 build tag")
+	}
+
+	// Phrase search ranks the matching track first
+	names := searchNames(t, testServer,
+		`/?mode=fulltext&search=%22jesus+of+suburbia%22`)
+	if len(names) == 0 || names[0] != "Jesus Of Suburbia / City Of The "+
+		"Damned / I Don't Care / Dearly Beloved / Tales Of Another Broken Home" {
+		t.Errorf("phrase search returned wrong tracks: %v", names)
+	}
+
+	// Artist names are searched with diacritics removed
+	names = searchNames(t, testServer,
+		"/?mode=fulltext&search=motorhead&limit=1000")
+	if len(names) != 15 {
+		t.Errorf("artist search returned %d tracks, want 15", len(names))
+	}
+
+	// Restricting the search to track names excludes matches on the artist
+	names = searchNames(t, testServer,
+		"/?mode=fulltext&search=motorhead&search_fields=name")
+	if len(names) != 0 {
+		t.Errorf("name search returned wrong tracks: %v", names)
+	}
+	names = searchNames(t, testServer,
+		"/?mode=fulltext&search=motorhead&search_fields=artist,album&limit=1000")
+	if len(names) != 15 {
+		t.Errorf("artist and album search returned %d tracks, want 15",
+			len(names))
+	}
+
+	// Prefix search
+	names = searchNames(t, testServer,
+		"/?mode=fulltext&search=suburb*&search_fields=name")
+	if len(names) != 1 {
+		t.Errorf("prefix search returned wrong tracks: %v", names)
+	}
+}
+
+func TestFullTextSearchErrors(t *testing.T) {
+	type errorCase struct {
+		url    string
+		status int
+		code   string
+		field  string
+	}
+	tests := []errorCase{
+		{"/?search=jump&mode=regex", http.StatusBadRequest, "invalid_mode",
+			"mode"},
+		{"/?search=jump&search_fields=name", http.StatusBadRequest,
+			"invalid_search_fields", "search_fields"},
+	}
+	if testServer.store.FullText() {
+		tests = append(tests,
+			errorCase{"/?search=jump&mode=fulltext&search_fields=genre",
+				http.StatusBadRequest, "invalid_search_fields", "search_fields"},
+			errorCase{"/?search=%22%22&mode=fulltext", http.StatusBadRequest,
+				"empty_search", "search"},
+			errorCase{"/?search=jump&mode=fulltext&cursor=abc",
+				http.StatusBadRequest, "invalid_cursor", "cursor"})
+	} else {
+		tests = append(tests, errorCase{"/?search=jump&mode=fulltext",
+			http.StatusNotImplemented, "fulltext_unavailable", "mode"})
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		testServer.routes().ServeHTTP(rec,
+			httptest.NewRequest(http.MethodGet, test.url, nil))
+		if rec.Code != test.status {
+			t.Errorf("%s returned wrong status code: \n\ngot\n\n%v"+
+				"\n\nwant\n\n%v", test.url, rec.Code, test.status)
+		}
+		ResponseErrorTest(rec, test.code, test.field, t)
+	}
+}
+
+// Function to copy the Chinook database so a test can write to it
+func copyDatabase(t *testing.T) string {
+	src, err := os.Open("./Chinook_Sqlite.sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	path := filepath.Join(t.TempDir(), "Chinook_Sqlite.sqlite")
+	dst, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Ensure writes to the database are reflected in the full-text index
+func TestFullTextIndexSync(t *testing.T) {
+	if !testServer.store.FullText() {
+		t.Skip("full-text search needs the sqlite_fts5 build tag")
+	}
+
+	config := defaultConfig()
+	config.DBPath = copyDatabase(t)
+	config.ReadOnly = false
+	config.MaxOpenConns = 2
+	store, err := OpenStore(config.storeOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	server := NewServer(store, config)
+
+	target := "/?mode=fulltext&search=zyzzyva"
+	if names := searchNames(t, server, target); len(names) != 0 {
+		t.Fatalf("search matched before insert: %v", names)
+	}
+
+	_, err = store.db.Exec("INSERT INTO Track (TrackId, Name, AlbumId, " +
+		"MediaTypeId, GenreId, Milliseconds, UnitPrice) " +
+		"VALUES (9001, 'Zyzzyva', 1, 1, 1, 1000, 0.99)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := searchNames(t, server, target); len(names) != 1 {
+		t.Errorf("search after insert returned wrong tracks: %v", names)
+	}
+
+	// Album artist is indexed through the album
+	if names := searchNames(t, server,
+		"/?mode=fulltext&search=zyzzyva+ac/dc"); len(names) != 1 {
+		t.Errorf("artist search after insert returned wrong tracks: %v",
+			names)
+	}
+
+	_, err = store.db.Exec("DELETE FROM Track WHERE TrackId = 9001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := searchNames(t, server, target); len(names) != 0 {
+		t.Errorf("search matched after delete: %v", names)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Name of the SQL function registered on every connection to score fuzzy
+// matches, see connectHook in store.go
+const fuzzyScoreFunc = "fuzzy_score"
+
+// Fuzzy score of a track, bound to the folded search text
+const fuzzyScoreExpr = fuzzyScoreFunc + "(?, track.Name, artist.Name, album.Title)"
+
+// Table of every track's fuzzy score, bound to the folded search text
+// Selecting, filtering and ordering on its column calls fuzzy_score once per
+// track rather than once for each use. The limit stops SQLite flattening
+// the subquery into the outer query, which would copy the call back in
+const fuzzyJoin = "INNER JOIN (SELECT track.TrackId AS TrackId, " +
+	fuzzyScoreExpr + " AS Score " + trackFrom + " LIMIT -1) AS fuzzy " +
+	"ON fuzzy.TrackId = track.TrackId"
+
+// Column of fuzzyJoin holding the score
+const fuzzyScoreColumn = "fuzzy.Score"
+
+// Longest fuzzy search and longest word in one, in letters
+// Scoring compares every word of the search with every word of each track,
+// so the time taken grows with their length
+const (
+	maxFuzzySearch = 100
+	maxFuzzyWord   = 30
+)
+
+// Letters that do not decompose into a base letter and an accent
+var foldReplacer = strings.NewReplacer(
+	"ø", "o", "æ", "ae", "œ", "oe", "ß", "ss", "ł", "l", "đ", "d", "ð", "d",
+	"þ", "th", "ı", "i",
+)
+
+// Function to normalize text for fuzzy matching
+// The text is lower cased and accents are removed, so "Motörhead" and
+// "motorhead" fold to the same string
+func foldText(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(t, s)
+	if err != nil {
+		folded = s
+	}
+	return foldReplacer.Replace(strings.ToLower(folded))
+}
+
+// Function to split folded text into words of letters and digits
+func foldedWords(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Function to count the edits needed to turn a into b, where an edit
+// inserts, deletes or replaces a letter or swaps two adjacent letters
+func editDistance(a []rune, b []rune) int {
+	// Rows of the dynamic programming table for the previous two letters
+	// of a and the current letter
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = minInt(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// Function to get the smaller of two ints
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Function to score how alike two words are from 0 to 1
+func wordSimilarity(a string, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 0
+	}
+	return 1 - float64(editDistance(ra, rb))/float64(longest)
+}
+
+// Function to score how well folded query text matches a target from 0 to 1
+// A target containing the whole query scores 1, otherwise each query word
+// is scored against its closest word in the target and the scores averaged
+func fuzzyScore(query string, target string) float64 {
+	folded := foldText(target)
+	if strings.Contains(folded, query) {
+		return 1
+	}
+
+	queryWords := foldedWords(query)
+	targetWords := foldedWords(folded)
+	if len(queryWords) == 0 || len(targetWords) == 0 {
+		return 0
+	}
+	total := 0.0
+	for _, qw := range queryWords {
+		best := 0.0
+		for _, tw := range targetWords {
+			if s := wordSimilarity(qw, tw); s > best {
+				best = s
+			}
+		}
+		total += best
+	}
+	return total / float64(len(queryWords))
+}
+
+// Function implementing the fuzzy_score SQL function
+// Returns the best score of the folded query against each target, rounded
+// to four decimal places. NULL targets are skipped
+func fuzzyScoreSQL(query string, targets ...interface{}) float64 {
+	best := 0.0
+	for _, target := range targets {
+		var s string
+		switch v := target.(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		default:
+			continue
+		}
+		if score := fuzzyScore(query, s); score > best {
+			best = score
+		}
+	}
+	return math.Round(best*10000) / 10000
+}
+
+// Function to check that folded search text is short enough to be matched
+// fuzzily
+func checkFuzzySearch(folded string) *apiError {
+	if n := utf8.RuneCountInString(folded); n > maxFuzzySearch {
+		e := errSearchTooLong.withMessage(
+			"Fuzzy searches may be at most %d letters long, got %d",
+			maxFuzzySearch, n)
+		return &e
+	}
+	for _, word := range foldedWords(folded) {
+		if n := utf8.RuneCountInString(word); n > maxFuzzyWord {
+			e := errSearchTooLong.withMessage(
+				"Words in fuzzy searches may be at most %d letters long, "+
+					"got %d", maxFuzzyWord, n)
+			return &e
+		}
+	}
+	return nil
+}
+
+// Function to add a fuzzy match of the track name, artist and album to a
+// query, best scores first
+// The score is selected as an extra column after the track columns
+func searchFuzzy(q *queryBuilder, folded string, threshold float64) *queryBuilder {
+	q.Join(fuzzyJoin, folded)
+	q.Select(fuzzyScoreColumn)
+	q.Where(fuzzyScoreColumn+" >= ?", threshold)
+	q.OrderBy(fuzzyScoreColumn + " DESC")
+	q.OrderBy("track.Name")
+	q.OrderBy("track.TrackId")
+	return q
+}
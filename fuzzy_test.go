@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFoldText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Motörhead", "motorhead"},
+		{"Mötley Crüe", "motley crue"},
+		{"Antônio Carlos Jobim", "antonio carlos jobim"},
+		{"Søren Straße", "soren strasse"},
+		{"AC/DC", "ac/dc"},
+	}
+
+	for _, test := range tests {
+		if got := foldText(test.text); got != test.want {
+			t.Errorf("foldText(%q): \n\ngot\n\n%v\n\nwant\n\n%v",
+				test.text, got, test.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want int
+	}{
+		{"", "", 0},
+		{"jump", "jump", 0},
+		{"jump", "", 4},
+		{"suburbai", "suburbia", 1},
+		{"kitten", "sitting", 3},
+		{"metalica", "metallica", 1},
+	}
+
+	for _, test := range tests {
+		got := editDistance([]rune(test.a), []rune(test.b))
+		if got != test.want {
+			t.Errorf("editDistance(%q, %q): \n\ngot\n\n%v\n\nwant\n\n%v",
+				test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestFuzzyScore(t *testing.T) {
+	if got := fuzzyScore("motorhead", "Motörhead"); got != 1 {
+		t.Errorf("accented match scored %v, want 1", got)
+	}
+	if got := fuzzyScore("road crew", "(We Are) The Road Crew"); got != 1 {
+		t.Errorf("substring match scored %v, want 1", got)
+	}
+	typo := fuzzyScore("metalica", "Metallica")
+	if typo < 0.8 || typo >= 1 {
+		t.Errorf("misspelling scored %v, want from 0.8 to 1", typo)
+	}
+	if got := fuzzyScore("zeppelin", "Iron Maiden"); got >= 0.5 {
+		t.Errorf("unrelated text scored %v, want below 0.5", got)
+	}
+	if got := fuzzyScoreSQL("motorhead", nil, []byte("Motörhead")); got != 1 {
+		t.Errorf("fuzzy_score skipping NULL scored %v, want 1", got)
+	}
+}
+
+func TestFuzzySearch(t *testing.T) {
+	// Unaccented search finds every Motörhead track
+	names := searchNames(t, testServer,
+		"/?search=motorhead&fuzzy=true&limit=1000")
+	if len(names) != 15 {
+		t.Errorf("accent folded search returned %d tracks, want 15",
+			len(names))
+	}
+
+	// A misspelled name ranks the intended track first with its score
+	rec := httptest.NewRecorder()
+	testServer.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+		"/?search=jesus+of+suburbai&fuzzy=true", nil))
+	var tracks []struct {
+		TrackId int
+		Score   *float64
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &tracks); err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) == 0 || tracks[0].TrackId != 1134 {
+		t.Fatalf("misspelled search returned wrong tracks: %+v", tracks)
+	}
+	if score := tracks[0].Score; score == nil || *score < 0.9 || *score >= 1 {
+		t.Errorf("misspelled search returned wrong score: %v", score)
+	}
+	for i := 1; i < len(tracks); i++ {
+		if tracks[i].Score == nil || *tracks[i].Score > *tracks[i-1].Score {
+			t.Errorf("tracks are not ordered by score: %v after %v",
+				tracks[i].Score, *tracks[i-1].Score)
+		}
+	}
+
+	// Substring searches do not include a score
+	rec = httptest.NewRecorder()
+	testServer.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+		"/?search=suburbia", nil))
+	var plain []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &plain); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := plain[0]["Score"]; ok {
+		t.Errorf("substring search returned a score: %v", plain[0])
+	}
+}
+
+func TestFuzzySearchErrors(t *testing.T) {
+	type errorCase struct {
+		url    string
+		status int
+		code   string
+		field  string
+	}
+	tests := []errorCase{
+		{"/?search=jump&fuzzy=maybe", http.StatusBadRequest, "invalid_fuzzy",
+			"fuzzy"},
+		{"/?search=%21%21&fuzzy=true", http.StatusBadRequest, "empty_search",
+			"search"},
+		{"/?search=jump&fuzzy=true&cursor=abc", http.StatusBadRequest,
+			"invalid_cursor", "cursor"},
+		{"/?fuzzy=true&search=" + strings.Repeat("love+", 21),
+			http.StatusBadRequest, "search_too_long", "search"},
+		{"/?fuzzy=true&search=" + strings.Repeat("a", 31),
+			http.StatusBadRequest, "search_too_long", "search"},
+	}
+	if testServer.store.FullText() {
+		tests = append(tests, errorCase{"/?search=jump&fuzzy=true&mode=fulltext",
+			http.StatusBadRequest, "invalid_fuzzy", "fuzzy"})
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		testServer.routes().ServeHTTP(rec,
+			httptest.NewRequest(http.MethodGet, test.url, nil))
+		if rec.Code != test.status {
+			t.Errorf("%s returned wrong status code: \n\ngot\n\n%v"+
+				"\n\nwant\n\n%v", test.url, rec.Code, test.status)
+		}
+		ResponseErrorTest(rec, test.code, test.field, t)
+	}
+}
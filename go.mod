@@ -2,4 +2,9 @@ module learn
 
 go 1.17
 
-require github.com/mattn/go-sqlite3 v1.14.10
+require (
+	github.com/andybalholm/brotli v1.1.0
+	github.com/mattn/go-sqlite3 v1.14.10
+	golang.org/x/text v0.13.0
+	gopkg.in/yaml.v3 v3.0.1
+)
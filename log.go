@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// logLevel orders log messages by severity
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+// Names accepted for the log_level setting
+var logLevelNames = map[string]logLevel{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+// Messages below this level are not written to the log
+var minLogLevel = levelInfo
+
+// Function to convert a log level name to a logLevel
+func parseLogLevel(name string) (logLevel, error) {
+	level, ok := logLevelNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown log level %q, "+
+			"must be one of debug, info, warn or error", name)
+	}
+	return level, nil
+}
+
+// Function to print a message to the log if its level is enabled
+func logAt(level logLevel, v ...interface{}) {
+	if level >= minLogLevel {
+		log.Println(v...)
+	}
+}
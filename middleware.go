@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+)
+
+// Key type for values the middleware stores in the request context
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	pathParamsKey
+	prettyKey
+)
+
+// Header used to pass a request ID in and out of the server
+const requestIDHeader = "X-Request-ID"
+
+// Function to create a random request ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// Middleware that gives every request an ID, reusing one sent by the client
+// The ID is echoed in the response headers and included in error responses
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > 64 {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Function to get the ID assigned to a request by withRequestID
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// Middleware that reads the pretty parameter accepted by every endpoint,
+// refusing a value that is not true or false before any work is done
+func withPretty(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value := r.URL.Query().Get("pretty")
+		if value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		pretty, err := strconv.ParseBool(value)
+		if err != nil {
+			errorHandler(w, r, errInvalidPretty.withMessage(
+				"Pretty must be true or false, got %q", value))
+			return
+		}
+		ctx := context.WithValue(r.Context(), prettyKey, pretty)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Function to check whether a request asked for indented JSON
+func prettyJSON(r *http.Request) bool {
+	pretty, _ := r.Context().Value(prettyKey).(bool)
+	return pretty
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// pageInfo describes which slice of the matching tracks a response holds
+// Limit is null when every match is returned, Offset is null for pages
+// that follow a cursor, Next and Prev are null when there is no such page
+type pageInfo struct {
+	Total  int     `json:"total"`
+	Limit  *int    `json:"limit"`
+	Offset *int    `json:"offset"`
+	Next   *string `json:"next"`
+	Prev   *string `json:"prev"`
+
+	// Set when more tracks follow the page, see cursor.go
+	NextCursor *string `json:"next_cursor,omitempty"`
+
+	first string
+	last  string
+}
+
+// Function to get the URL of the page starting at offset, keeping every
+// other parameter of the request
+func pageURL(u *url.URL, offset int) string {
+	values := u.Query()
+	values.Set("offset", strconv.Itoa(offset))
+	return u.Path + "?" + values.Encode()
+}
+
+// Function to describe the page of results selected by q
+func newPageInfo(u *url.URL, total int, q *queryBuilder) pageInfo {
+	offset := 0
+	p := pageInfo{Total: total, Offset: &offset}
+	if !q.hasLimit {
+		return p
+	}
+
+	limit := q.limit
+	p.Limit = &limit
+	if q.hasOffset {
+		offset = q.offset
+	}
+	if limit < 1 {
+		return p
+	}
+
+	if offset+limit < total {
+		next := pageURL(u, offset+limit)
+		p.Next = &next
+	}
+	if offset > 0 {
+		prevOffset := offset - limit
+		if prevOffset < 0 {
+			prevOffset = 0
+		}
+		prev := pageURL(u, prevOffset)
+		p.Prev = &prev
+	}
+	p.first = pageURL(u, 0)
+	if total > 0 {
+		p.last = pageURL(u, (total-1)/limit*limit)
+	}
+	return p
+}
+
+// Function to describe a page of results that follows a cursor
+// Offset links do not apply, the next link is added once the last track of
+// the page is known
+func newCursorPageInfo(total int, q *queryBuilder) pageInfo {
+	p := pageInfo{Total: total}
+	if q.hasLimit {
+		limit := q.limit
+		p.Limit = &limit
+	}
+	return p
+}
+
+// Function to record the cursor for the page after this one
+// Pages that followed a cursor also get a next link using the new cursor
+func (p *pageInfo) setNextCursor(u *url.URL, token string, cursorMode bool) {
+	p.NextCursor = &token
+	if cursorMode {
+		values := u.Query()
+		values.Set("cursor", token)
+		next := u.Path + "?" + values.Encode()
+		p.Next = &next
+	}
+}
+
+// Function to set the X-Total-Count and RFC 5988 Link headers for a page
+// with set, such as the Set method of the response headers
+func (p pageInfo) setHeaders(set func(name string, value string)) {
+	set("X-Total-Count", strconv.Itoa(p.Total))
+	if links := p.links(); links != "" {
+		set("Link", links)
+	}
+}
+
+// Function to get the value of the RFC 5988 Link header for a page, or an
+// empty string if there are no other pages
+func (p pageInfo) links() string {
+	var links []string
+	addLink := func(target string, rel string) {
+		if target != "" {
+			links = append(links, "<"+target+`>; rel="`+rel+`"`)
+		}
+	}
+	if p.Next != nil {
+		addLink(*p.Next, "next")
+	}
+	if p.Prev != nil {
+		addLink(*p.Prev, "prev")
+	}
+	addLink(p.first, "first")
+	addLink(p.last, "last")
+	return strings.Join(links, ", ")
+}
+
+// Function to get the text written before and after the tracks when the
+// response is wrapped in a pagination envelope
+func (p pageInfo) envelope() (prefix string, suffix string) {
+	var meta bytes.Buffer
+	enc := json.NewEncoder(&meta)
+	enc.SetEscapeHTML(false)
+	enc.Encode(p)
+	// Replace the opening brace of the metadata object with the end of the
+	// data array so both share one object
+	return `{"data":[`, "]," + strings.TrimSpace(meta.String()[1:])
+}
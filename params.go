@@ -0,0 +1,314 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Ways the search text can be matched against tracks
+const (
+	// Substring of the track name, ranked exact, prefix then contains
+	modeSubstring = "substring"
+	// Full-text search of name, album, artist and composer ranked by BM25
+	modeFullText = "fulltext"
+	// Typo-tolerant match of name, artist and album, chosen with fuzzy=true
+	modeFuzzy = "fuzzy"
+	// Every track in TrackId order, used when no search is given to /tracks
+	modeBrowse = "browse"
+)
+
+// searchParams holds the validated URL parameters of a track search
+// Every rule for the parameters is applied in parseSearchParams so the
+// handler only deals with values known to be valid
+type searchParams struct {
+	Search    string
+	Mode      string
+	Match     string
+	Limit     int
+	HasLimit  bool
+	Offset    int
+	HasOffset bool
+	Envelope  bool
+	After     *cursor
+	Filters   []filterValue
+	Sort      []sortKey
+	Fields    []trackField
+	Expand    []trackExpansion
+}
+
+// Function to read and validate the URL parameters of a search request
+// A parameter given with an empty value is treated as missing, except for
+// a required search. Without a required search, no search browses every
+// track
+func (s *Server) parseSearchParams(r *http.Request,
+	searchRequired bool) (searchParams, *apiError) {
+	var p searchParams
+	values := r.URL.Query()
+
+	// Search must not be empty when it is required
+	searchTerms, ok := values["search"]
+	if !ok && searchRequired {
+		return p, &errMissingSearch
+	}
+	// Query()["search"] will return an array of parameters,
+	// we only want a single parameter
+	if ok {
+		p.Search = searchTerms[0]
+	}
+	if len(p.Search) < 1 && searchRequired {
+		return p, &errEmptySearch
+	}
+	if len(p.Search) < 1 {
+		return s.parseBrowseParams(values)
+	}
+
+	// Search mode defaults to substring matching of the track name
+	switch p.Mode = values.Get("mode"); p.Mode {
+	case "":
+		p.Mode = modeSubstring
+	case modeSubstring:
+	case modeFullText:
+		if !s.store.FullText() {
+			return p, &errFullTextUnavailable
+		}
+	default:
+		e := errInvalidMode.withMessage(
+			"Mode must be %s or %s, got %q", modeSubstring, modeFullText,
+			p.Mode)
+		return p, &e
+	}
+
+	// Fuzzy matching replaces substring matching
+	if value := values.Get("fuzzy"); value != "" {
+		fuzzy, err := strconv.ParseBool(value)
+		if err != nil {
+			e := errInvalidFuzzy.withMessage(
+				"Fuzzy must be true or false, got %q", value)
+			return p, &e
+		}
+		if fuzzy && p.Mode != modeSubstring {
+			e := errInvalidFuzzy.withMessage(
+				"Fuzzy cannot be used with mode=%s", p.Mode)
+			return p, &e
+		}
+		if fuzzy {
+			p.Mode = modeFuzzy
+			if p.Match = foldText(p.Search); len(foldedWords(p.Match)) == 0 {
+				return p, &errEmptySearch
+			}
+			if e := checkFuzzySearch(p.Match); e != nil {
+				return p, e
+			}
+		}
+	}
+
+	// Columns searched in full-text mode, all of them by default
+	var columns []string
+	if value := values.Get("search_fields"); value != "" {
+		if p.Mode != modeFullText {
+			e := errInvalidSearchFields.withMessage(
+				"Search fields can only be used with mode=%s", modeFullText)
+			return p, &e
+		}
+		for _, column := range strings.Split(value, ",") {
+			column = strings.ToLower(strings.TrimSpace(column))
+			if !containsString(ftsColumns, column) {
+				e := errInvalidSearchFields.withMessage(
+					"Unknown search field %q, must be one of %s", column,
+					strings.Join(ftsColumns, ", "))
+				return p, &e
+			}
+			if !containsString(columns, column) {
+				columns = append(columns, column)
+			}
+		}
+	}
+	if p.Mode == modeFullText {
+		if p.Match = ftsQuery(p.Search, columns); p.Match == "" {
+			return p, &errEmptySearch
+		}
+	}
+
+	return s.parsePageParams(values, p)
+}
+
+// Function to read and validate the URL parameters of a request browsing
+// every track
+// Search modes need a search, so they are refused
+func (s *Server) parseBrowseParams(values url.Values) (searchParams, *apiError) {
+	p := searchParams{Mode: modeBrowse}
+	if mode := values.Get("mode"); mode != "" && mode != modeSubstring {
+		e := errMissingSearch.withMessage(
+			"The search parameter is required with mode=%s", mode)
+		return p, &e
+	}
+	if value := values.Get("fuzzy"); value != "" {
+		fuzzy, err := strconv.ParseBool(value)
+		if err != nil {
+			e := errInvalidFuzzy.withMessage(
+				"Fuzzy must be true or false, got %q", value)
+			return p, &e
+		}
+		if fuzzy {
+			e := errMissingSearch.withMessage(
+				"The search parameter is required with fuzzy=true")
+			return p, &e
+		}
+	}
+	if values.Get("search_fields") != "" {
+		e := errInvalidSearchFields.withMessage(
+			"Search fields can only be used with mode=%s", modeFullText)
+		return p, &e
+	}
+	return s.parsePageParams(values, p)
+}
+
+// Function to read and validate the filter and paging parameters shared by
+// searching and browsing
+func (s *Server) parsePageParams(values url.Values,
+	p searchParams) (searchParams, *apiError) {
+	// Filters narrow the tracks matched by the search
+	filters, ferr := parseFilters(values)
+	if ferr != nil {
+		return p, ferr
+	}
+	p.Filters = filters
+
+	// Fields trim each track to the chosen fields, all fields by default
+	if value := values.Get("fields"); value != "" {
+		fields, ferr := parseFields(value, p.Mode)
+		if ferr != nil {
+			return p, ferr
+		}
+		p.Fields = fields
+	}
+
+	// Related entities are embedded in each track when expanded
+	if value := values.Get("expand"); value != "" {
+		expansions, xerr := parseExpand(value)
+		if xerr != nil {
+			return p, xerr
+		}
+		p.Expand = expansions
+	}
+
+	// Sort replaces the default order of the mode
+	if value := values.Get("sort"); value != "" {
+		keys, serr := parseSort(value, p.Mode)
+		if serr != nil {
+			return p, serr
+		}
+		p.Sort = keys
+	}
+
+	// Limit falls back to the default page size
+	limit, hasLimit, lerr := s.parseLimit(values, s.config.DefaultPageSize)
+	if lerr != nil {
+		return p, lerr
+	}
+	p.Limit, p.HasLimit = limit, hasLimit
+
+	// Offset can be used with or without a limit
+	offset, hasOffset, oerr := parseOffset(values)
+	if oerr != nil {
+		return p, oerr
+	}
+	p.Offset, p.HasOffset = offset, hasOffset
+
+	// Results are a bare array unless the envelope is requested
+	if value := values.Get("envelope"); value != "" {
+		envelope, err := strconv.ParseBool(value)
+		if err != nil {
+			e := errInvalidEnvelope.withMessage(
+				"Envelope must be true or false, got %q", value)
+			return p, &e
+		}
+		p.Envelope = envelope
+	}
+
+	// A cursor takes the place of an offset and only applies to the search
+	// it was issued for
+	if token := values.Get("cursor"); token != "" {
+		if p.Mode != modeSubstring && p.Mode != modeBrowse {
+			e := errInvalidCursor.withMessage(
+				"Cursors can only be used with mode=%s", modeSubstring)
+			return p, &e
+		}
+		if p.HasOffset {
+			return p, &errCursorWithOffset
+		}
+		if len(p.Sort) > 0 {
+			return p, &errCursorWithSort
+		}
+		c, err := decodeCursor(s.cursorSecret, token)
+		if err != nil || c.Search != p.Search {
+			return p, &errInvalidCursor
+		}
+		p.After = &c
+	}
+
+	return p, nil
+}
+
+// Function to apply the page size and offset to a query
+func (p searchParams) page(q *queryBuilder) *queryBuilder {
+	if p.HasLimit {
+		q.Limit(p.Limit)
+	}
+	if p.HasOffset {
+		q.Offset(p.Offset)
+	}
+	return q
+}
+
+// Function to check whether a list of strings contains a value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Function to read the limit parameter, which may not exceed the maximum
+// page size
+// The fallback is used when no limit is given, 0 meaning no limit
+func (s *Server) parseLimit(values url.Values,
+	fallback int) (int, bool, *apiError) {
+	value := values.Get("limit")
+	if value == "" {
+		return fallback, fallback > 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 ||
+		(s.config.MaxPageSize > 0 && n > s.config.MaxPageSize) {
+		e := errInvalidLimit.withMessage(
+			"Limit must be a positive integer, got %q", value)
+		if s.config.MaxPageSize > 0 {
+			e = errInvalidLimit.withMessage(
+				"Limit must be an integer from 1 to %d, got %q",
+				s.config.MaxPageSize, value)
+		}
+		return 0, false, &e
+	}
+	return n, true, nil
+}
+
+// Function to read the offset parameter, which can be used with or without
+// a limit
+func parseOffset(values url.Values) (int, bool, *apiError) {
+	value := values.Get("offset")
+	if value == "" {
+		return 0, false, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		e := errInvalidOffset.withMessage(
+			"Offset must be zero or a positive integer, got %q", value)
+		return 0, false, &e
+	}
+	return n, true, nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"strings"
+)
+
+// Customer is a row of the Customer table
+// Address, PostalCode, Phone, Fax and Email are personal data, redacted
+// unless the request is authorized
+type Customer struct {
+	CustomerId   NullInt64  `json:"CustomerId"`
+	FirstName    NullString `json:"FirstName"`
+	LastName     NullString `json:"LastName"`
+	Company      NullString `json:"Company"`
+	Address      NullString `json:"Address"`
+	City         NullString `json:"City"`
+	State        NullString `json:"State"`
+	Country      NullString `json:"Country"`
+	PostalCode   NullString `json:"PostalCode"`
+	Phone        NullString `json:"Phone"`
+	Fax          NullString `json:"Fax"`
+	Email        NullString `json:"Email"`
+	SupportRepId NullInt64  `json:"SupportRepId"`
+}
+
+// Employee is a row of the Employee table
+// BirthDate, Address, PostalCode, Phone, Fax and Email are personal data,
+// redacted unless the request is authorized
+type Employee struct {
+	EmployeeId NullInt64  `json:"EmployeeId"`
+	LastName   NullString `json:"LastName"`
+	FirstName  NullString `json:"FirstName"`
+	Title      NullString `json:"Title"`
+	ReportsTo  NullInt64  `json:"ReportsTo"`
+	BirthDate  NullString `json:"BirthDate"`
+	HireDate   NullString `json:"HireDate"`
+	Address    NullString `json:"Address"`
+	City       NullString `json:"City"`
+	State      NullString `json:"State"`
+	Country    NullString `json:"Country"`
+	PostalCode NullString `json:"PostalCode"`
+	Phone      NullString `json:"Phone"`
+	Fax        NullString `json:"Fax"`
+	Email      NullString `json:"Email"`
+}
+
+// EmployeeReport is an employee in an org tree with the employees that
+// report to them
+type EmployeeReport struct {
+	*Employee
+	Reports []*EmployeeReport `json:"Reports"`
+}
+
+// Invoice is a row of the Invoice table
+// BillingAddress and BillingPostalCode are personal data, redacted unless
+// the request is authorized
+type Invoice struct {
+	InvoiceId         NullInt64   `json:"InvoiceId"`
+	CustomerId        NullInt64   `json:"CustomerId"`
+	InvoiceDate       NullString  `json:"InvoiceDate"`
+	BillingAddress    NullString  `json:"BillingAddress"`
+	BillingCity       NullString  `json:"BillingCity"`
+	BillingState      NullString  `json:"BillingState"`
+	BillingCountry    NullString  `json:"BillingCountry"`
+	BillingPostalCode NullString  `json:"BillingPostalCode"`
+	Total             NullFloat64 `json:"Total"`
+}
+
+// Statements reading the customer, employee and invoice tables
+const (
+	customerSelect = "SELECT CustomerId, FirstName, LastName, Company, " +
+		"Address, City, State, Country, PostalCode, Phone, Fax, Email, " +
+		"SupportRepId FROM customer"
+	employeeColumns = "EmployeeId, LastName, FirstName, Title, ReportsTo, " +
+		"BirthDate, HireDate, Address, City, State, Country, PostalCode, " +
+		"Phone, Fax, Email"
+	employeeSelect = "SELECT " + employeeColumns + " FROM employee"
+	invoiceSelect  = "SELECT InvoiceId, CustomerId, InvoiceDate, " +
+		"BillingAddress, BillingCity, BillingState, BillingCountry, " +
+		"BillingPostalCode, Total FROM invoice"
+)
+
+// Statement reading every employee below an employee in the org tree
+// UNION rather than UNION ALL stops at a cycle in ReportsTo
+const employeeReportsSelect = "WITH RECURSIVE reports(EmployeeId) AS (" +
+	"SELECT EmployeeId FROM employee WHERE ReportsTo = ? " +
+	"UNION SELECT employee.EmployeeId FROM employee " +
+	"INNER JOIN reports ON employee.ReportsTo = reports.EmployeeId) " +
+	employeeSelect + " WHERE EmployeeId IN (SELECT EmployeeId FROM reports) " +
+	"ORDER BY EmployeeId"
+
+// redact clears the personal data of a customer
+func (c *Customer) redact() {
+	for _, field := range []*NullString{&c.Address, &c.PostalCode, &c.Phone,
+		&c.Fax, &c.Email} {
+		*field = NullString{}
+	}
+}
+
+// redact clears the personal data of an employee
+func (e *Employee) redact() {
+	for _, field := range []*NullString{&e.BirthDate, &e.Address,
+		&e.PostalCode, &e.Phone, &e.Fax, &e.Email} {
+		*field = NullString{}
+	}
+}
+
+// redact clears the personal data of an invoice
+func (i *Invoice) redact() {
+	i.BillingAddress = NullString{}
+	i.BillingPostalCode = NullString{}
+}
+
+// Function to create a scanner for rows of customerSelect, redacting each
+// customer unless authorized
+func scanCustomers(authorized bool) func(*sql.Rows) (interface{}, error) {
+	return func(rows *sql.Rows) (interface{}, error) {
+		var c Customer
+		err := rows.Scan(&c.CustomerId, &c.FirstName, &c.LastName,
+			&c.Company, &c.Address, &c.City, &c.State, &c.Country,
+			&c.PostalCode, &c.Phone, &c.Fax, &c.Email, &c.SupportRepId)
+		if !authorized {
+			c.redact()
+		}
+		return &c, err
+	}
+}
+
+// Function to create a scanner for rows of employeeSelect, redacting each
+// employee unless authorized
+func scanEmployees(authorized bool) func(*sql.Rows) (interface{}, error) {
+	return func(rows *sql.Rows) (interface{}, error) {
+		var e Employee
+		err := rows.Scan(&e.EmployeeId, &e.LastName, &e.FirstName, &e.Title,
+			&e.ReportsTo, &e.BirthDate, &e.HireDate, &e.Address, &e.City,
+			&e.State, &e.Country, &e.PostalCode, &e.Phone, &e.Fax, &e.Email)
+		if !authorized {
+			e.redact()
+		}
+		return &e, err
+	}
+}
+
+// Function to create a scanner for rows of invoiceSelect, redacting each
+// invoice unless authorized
+func scanInvoices(authorized bool) func(*sql.Rows) (interface{}, error) {
+	return func(rows *sql.Rows) (interface{}, error) {
+		var i Invoice
+		err := rows.Scan(&i.InvoiceId, &i.CustomerId, &i.InvoiceDate,
+			&i.BillingAddress, &i.BillingCity, &i.BillingState,
+			&i.BillingCountry, &i.BillingPostalCode, &i.Total)
+		if !authorized {
+			i.redact()
+		}
+		return &i, err
+	}
+}
+
+// Function to check whether a request may read personal data
+// Requests without an Authorization header are answered with personal data
+// redacted, while a wrong token is refused with a 401 error. No request is
+// authorized when api_token is not configured. The response varies with the
+// header, so caches keep the redacted and full responses apart
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) (bool,
+	bool) {
+	w.Header().Add("Vary", "Authorization")
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return false, true
+	}
+	const prefix = "Bearer "
+	token := ""
+	if len(header) > len(prefix) &&
+		strings.EqualFold(header[:len(prefix)], prefix) {
+		token = header[len(prefix):]
+	}
+	if s.config.APIToken == "" || subtle.ConstantTimeCompare([]byte(token),
+		[]byte(s.config.APIToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="chinook"`)
+		errorHandler(w, r, errUnauthorized)
+		return false, false
+	}
+	return true, true
+}
+
+// Request handler function for every customer
+func (s *Server) customersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	authorized, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+	s.serveRows(w, r, customerSelect+" ORDER BY CustomerId", nil,
+		scanCustomers(authorized))
+}
+
+// Request handler function for a single customer
+func (s *Server) customerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	authorized, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+	s.serveRow(w, r, "Customer", customerSelect+" WHERE CustomerId = ?",
+		scanCustomers(authorized))
+}
+
+// Request handler function for the invoices of a customer
+func (s *Server) customerInvoicesHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	authorized, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+	id, ok := s.requireRow(w, r, "Customer",
+		"SELECT 1 FROM customer WHERE CustomerId = ?")
+	if !ok {
+		return
+	}
+	s.serveRows(w, r, invoiceSelect+" WHERE CustomerId = ? ORDER BY "+
+		"InvoiceDate, InvoiceId", []interface{}{id}, scanInvoices(authorized))
+}
+
+// Request handler function for every employee
+func (s *Server) employeesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	authorized, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+	s.serveRows(w, r, employeeSelect+" ORDER BY EmployeeId", nil,
+		scanEmployees(authorized))
+}
+
+// Request handler function for a single employee
+func (s *Server) employeeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	authorized, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+	s.serveRow(w, r, "Employee", employeeSelect+" WHERE EmployeeId = ?",
+		scanEmployees(authorized))
+}
+
+// Request handler function for the org tree below an employee
+// Each employee reporting directly to the employee is written with the
+// employees reporting to them, and so on down the tree
+func (s *Server) employeeReportsHandler(w http.ResponseWriter,
+	r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	authorized, ok := s.authorize(w, r)
+	if !ok {
+		return
+	}
+	id, ok := s.requireRow(w, r, "Employee",
+		"SELECT 1 FROM employee WHERE EmployeeId = ?")
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.QueryTimeout)
+	defer cancel()
+
+	items, err := s.queryRows(ctx, employeeReportsSelect, []interface{}{id},
+		scanEmployees(authorized))
+	if err != nil {
+		errorHandler(w, r, databaseError(err))
+		return
+	}
+	writeJSON(w, r, http.StatusOK, reportTree(id, items))
+}
+
+// Function to arrange the employees below an employee into a tree, returning
+// the employees reporting directly to it
+func reportTree(id int64, items []interface{}) []*EmployeeReport {
+	nodes := make(map[int64]*EmployeeReport, len(items))
+	for _, item := range items {
+		e := item.(*Employee)
+		nodes[e.EmployeeId.Int64] = &EmployeeReport{e, []*EmployeeReport{}}
+	}
+	// Items are ordered by id, so each list of reports is too
+	roots := []*EmployeeReport{}
+	for _, item := range items {
+		node := nodes[item.(*Employee).EmployeeId.Int64]
+		if node.ReportsTo.Int64 == id {
+			roots = append(roots, node)
+		} else if parent, ok := nodes[node.ReportsTo.Int64]; ok {
+			parent.Reports = append(parent.Reports, node)
+		}
+	}
+	return roots
+}
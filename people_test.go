@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRedaction(t *testing.T) {
+	config := defaultConfig()
+	config.APIToken = "s3cret"
+	server := NewServer(testServer.store, config)
+
+	// Personal fields are redacted and the kept field never is
+	tests := []struct {
+		target   string
+		personal []string
+		kept     string
+	}{
+		{"/customers/1",
+			[]string{"Address", "PostalCode", "Phone", "Fax", "Email"}, "City"},
+		{"/employees/1",
+			[]string{"BirthDate", "Address", "PostalCode", "Phone", "Email"},
+			"City"},
+		{"/customers/1/invoices",
+			[]string{"BillingAddress", "BillingPostalCode"}, "BillingCity"},
+	}
+	for _, test := range tests {
+		target := test.target
+		for _, authorization := range []string{"", "Bearer s3cret"} {
+			rec := getWithHeaders(server.routes(), target,
+				map[string]string{"Authorization": authorization})
+			if rec.Code != http.StatusOK {
+				t.Fatalf("%s returned wrong status code: \n\ngot\n\n%v\n\n"+
+					"want\n\n%v", target, rec.Code, http.StatusOK)
+			}
+			var body interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if list, ok := body.([]interface{}); ok {
+				body = list[0]
+			}
+			row := body.(map[string]interface{})
+			for _, field := range test.personal {
+				if redacted := row[field] == nil; redacted != (authorization == "") {
+					t.Errorf("%s with %q wrote %s as %v", target,
+						authorization, field, row[field])
+				}
+			}
+			if row[test.kept] == nil {
+				t.Errorf("%s redacted %s", target, test.kept)
+			}
+		}
+	}
+
+	// A wrong token is refused rather than redacted
+	for _, authorization := range []string{"Bearer nope", "s3cret"} {
+		rec := getWithHeaders(server.routes(), "/customers",
+			map[string]string{"Authorization": authorization})
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%q returned wrong status code: \n\ngot\n\n%v\n\nwant"+
+				"\n\n%v", authorization, rec.Code, http.StatusUnauthorized)
+		}
+		ResponseErrorTest(rec, "unauthorized", "", t)
+	}
+	// Without a configured token no request is authorized
+	rec := getWithHeaders(testServer.routes(), "/customers",
+		map[string]string{"Authorization": "Bearer "})
+	ResponseErrorTest(rec, "unauthorized", "", t)
+}
+
+func TestPeople(t *testing.T) {
+	customers, _ := getJSON(t, "/customers", http.StatusOK).([]interface{})
+	if len(customers) != 59 {
+		t.Errorf("wrong number of customers: \n\ngot\n\n%v\n\nwant\n\n%v",
+			len(customers), 59)
+	}
+	invoices, _ := getJSON(t, "/customers/1/invoices",
+		http.StatusOK).([]interface{})
+	if len(invoices) != 7 {
+		t.Errorf("wrong number of invoices: \n\ngot\n\n%v\n\nwant\n\n%v",
+			len(invoices), 7)
+	}
+	employees, _ := getJSON(t, "/employees", http.StatusOK).([]interface{})
+	if len(employees) != 8 {
+		t.Errorf("wrong number of employees: \n\ngot\n\n%v\n\nwant\n\n%v",
+			len(employees), 8)
+	}
+
+	// Function to reduce an org tree to employee ids
+	var ids func(tree interface{}) []interface{}
+	ids = func(tree interface{}) []interface{} {
+		var out []interface{}
+		for _, node := range tree.([]interface{}) {
+			e := node.(map[string]interface{})
+			out = append(out, e["EmployeeId"])
+			if reports := ids(e["Reports"]); reports != nil {
+				out = append(out, reports)
+			}
+		}
+		return out
+	}
+	want := []interface{}{2.0, []interface{}{3.0, 4.0, 5.0}, 6.0,
+		[]interface{}{7.0, 8.0}}
+	if got := ids(getJSON(t, "/employees/1/reports", http.StatusOK)); !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong org tree: \n\ngot\n\n%v\n\nwant\n\n%v", got, want)
+	}
+	if got := getJSON(t, "/employees/8/reports", http.StatusOK); !reflect.DeepEqual(got,
+		[]interface{}{}) {
+		t.Errorf("wrong org tree for an employee with no reports: %v", got)
+	}
+
+	getJSON(t, "/customers/99", http.StatusNotFound)
+	getJSON(t, "/customers/99/invoices", http.StatusNotFound)
+	getJSON(t, "/employees/99/reports", http.StatusNotFound)
+}
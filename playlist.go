@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+)
+
+// Playlist is a row of the Playlist table with the totals of its tracks
+type Playlist struct {
+	PlaylistId   NullInt64  `json:"PlaylistId"`
+	Name         NullString `json:"Name"`
+	TrackCount   int64      `json:"TrackCount"`
+	Milliseconds int64      `json:"Milliseconds"`
+	Bytes        int64      `json:"Bytes"`
+}
+
+// Statement reading playlists, a WHERE clause may follow
+// Tracks with no size count as zero bytes
+const playlistSelect = "SELECT playlist.PlaylistId, playlist.Name, " +
+	"COUNT(track.TrackId), COALESCE(SUM(track.Milliseconds), 0), " +
+	"COALESCE(SUM(track.Bytes), 0) FROM playlist " +
+	"LEFT JOIN playlisttrack " +
+	"ON playlisttrack.PlaylistId = playlist.PlaylistId " +
+	"LEFT JOIN track ON track.TrackId = playlisttrack.TrackId"
+
+// Clause grouping playlistSelect into one row per playlist
+const playlistGroup = " GROUP BY playlist.PlaylistId " +
+	"ORDER BY playlist.PlaylistId"
+
+// Clause limiting the tracks of /tracks to those on a playlist
+const onPlaylist = "track.TrackId IN " +
+	"(SELECT TrackId FROM playlisttrack WHERE PlaylistId = ?)"
+
+// Function to scan a row of playlistSelect
+func scanPlaylist(rows *sql.Rows) (interface{}, error) {
+	var p Playlist
+	err := rows.Scan(&p.PlaylistId, &p.Name, &p.TrackCount, &p.Milliseconds,
+		&p.Bytes)
+	return &p, err
+}
+
+// Request handler function for every playlist
+func (s *Server) playlistsHandler(w http.ResponseWriter, r *http.Request) {
+	s.serveRows(w, r, playlistSelect+playlistGroup, nil, scanPlaylist)
+}
+
+// Request handler function for a single playlist
+func (s *Server) playlistHandler(w http.ResponseWriter, r *http.Request) {
+	s.serveRow(w, r, "Playlist",
+		playlistSelect+" WHERE playlist.PlaylistId = ?"+playlistGroup,
+		scanPlaylist)
+}
+
+// Request handler function for the tracks of a playlist, which accepts the
+// parameters of /tracks
+func (s *Server) playlistTracksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	id, ok := s.requireRow(w, r, "Playlist",
+		"SELECT 1 FROM playlist WHERE PlaylistId = ?")
+	if !ok {
+		return
+	}
+	s.serveTracks(w, r, false, filterValue{onPlaylist, id})
+}
+
+// Request handler function for adding a track to a playlist with POST or
+// removing it with DELETE
+// POST returns the playlist with its new totals
+func (s *Server) playlistTrackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		methodNotAllowed(w, r, http.MethodPost, http.MethodDelete)
+		return
+	}
+	if !s.requireWritable(w, r) {
+		return
+	}
+	id, ok := pathID(r)
+	if !ok {
+		errorHandler(w, r, notFound("Playlist", r))
+		return
+	}
+	trackID, ok := pathInt(r, "trackId")
+	if !ok {
+		errorHandler(w, r, errNotFound.withMessage("Track %s not found",
+			pathParam(r, "trackId")))
+		return
+	}
+
+	var playlist *Playlist
+	ok = s.inTransaction(w, r, func(ctx context.Context,
+		tx *sql.Tx) (*apiError, error) {
+		var found int
+		err := tx.QueryRowContext(ctx,
+			"SELECT 1 FROM playlist WHERE PlaylistId = ?", id).Scan(&found)
+		if err == sql.ErrNoRows {
+			e := notFound("Playlist", r)
+			return &e, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if r.Method == http.MethodPost {
+			e, err := addPlaylistTrack(ctx, tx, id, trackID)
+			if e != nil || err != nil {
+				return e, err
+			}
+		} else {
+			result, err := tx.ExecContext(ctx, "DELETE FROM playlisttrack "+
+				"WHERE PlaylistId = ? AND TrackId = ?", id, trackID)
+			if err != nil {
+				return nil, err
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				e := errNotFound.withMessage(
+					"Track %d is not on playlist %d", trackID, id)
+				return &e, nil
+			}
+		}
+
+		playlist = &Playlist{}
+		err = tx.QueryRowContext(ctx,
+			playlistSelect+" WHERE playlist.PlaylistId = ?"+playlistGroup,
+			id).Scan(&playlist.PlaylistId, &playlist.Name,
+			&playlist.TrackCount, &playlist.Milliseconds, &playlist.Bytes)
+		return nil, err
+	})
+	if !ok {
+		return
+	}
+
+	ids := strconv.FormatInt(trackID, 10) + " on playlist " +
+		strconv.FormatInt(id, 10)
+	if r.Method == http.MethodDelete {
+		logAt(levelInfo, "Removed track "+ids)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	logAt(levelInfo, "Added track "+ids)
+	writeJSON(w, r, http.StatusOK, playlist)
+}
+
+// Function to add a track to a playlist within a transaction, refusing
+// tracks that do not exist or are already on the playlist
+func addPlaylistTrack(ctx context.Context, tx *sql.Tx, id int64,
+	trackID int64) (*apiError, error) {
+	var onList int
+	err := tx.QueryRowContext(ctx, "SELECT COUNT(playlisttrack.TrackId) "+
+		"FROM track LEFT JOIN playlisttrack "+
+		"ON playlisttrack.TrackId = track.TrackId "+
+		"AND playlisttrack.PlaylistId = ? WHERE track.TrackId = ? "+
+		"GROUP BY track.TrackId",
+		id, trackID).Scan(&onList)
+	if err == sql.ErrNoRows {
+		e := errNotFound.withMessage("Track %d not found", trackID)
+		return &e, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if onList > 0 {
+		e := errPlaylistTrackExists.withMessage(
+			"Track %d is already on playlist %d", trackID, id)
+		return &e, nil
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO playlisttrack "+
+		"(PlaylistId, TrackId) VALUES (?, ?)", id, trackID)
+	return nil, err
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestPlaylists(t *testing.T) {
+	playlists, _ := getJSON(t, "/playlists", http.StatusOK).([]interface{})
+	if len(playlists) != 18 {
+		t.Errorf("wrong number of playlists: \n\ngot\n\n%v\n\nwant\n\n%v",
+			len(playlists), 18)
+	}
+
+	want := map[string]interface{}{"PlaylistId": 13.0,
+		"Name": "Classical 101 - Deep Cuts", "TrackCount": 25.0,
+		"Milliseconds": 6755730.0, "Bytes": 131970219.0}
+	if got := getJSON(t, "/playlists/13", http.StatusOK); !reflect.DeepEqual(
+		got, want) {
+		t.Errorf("wrong playlist: \n\ngot\n\n%v\n\nwant\n\n%v", got, want)
+	}
+
+	// Empty playlists have zero totals
+	empty, _ := getJSON(t, "/playlists/2", http.StatusOK).(map[string]interface{})
+	if empty["TrackCount"] != 0.0 || empty["Milliseconds"] != 0.0 ||
+		empty["Bytes"] != 0.0 {
+		t.Errorf("wrong totals for an empty playlist: %v", empty)
+	}
+
+	tracks, _ := getJSON(t, "/playlists/9/tracks?fields=TrackId",
+		http.StatusOK).([]interface{})
+	wantTracks := []interface{}{map[string]interface{}{"TrackId": 3402.0}}
+	if !reflect.DeepEqual(tracks, wantTracks) {
+		t.Errorf("wrong playlist tracks: \n\ngot\n\n%v\n\nwant\n\n%v", tracks,
+			wantTracks)
+	}
+	page, _ := getJSON(t, "/playlists/13/tracks?limit=10&envelope=true",
+		http.StatusOK).(map[string]interface{})
+	if data, _ := page["data"].([]interface{}); len(data) != 10 ||
+		page["total"] != 25.0 {
+		t.Errorf("wrong page of playlist tracks: %v", page)
+	}
+
+	getJSON(t, "/playlists/99", http.StatusNotFound)
+	getJSON(t, "/playlists/99/tracks", http.StatusNotFound)
+}
+
+func TestPlaylistTracks(t *testing.T) {
+	config := defaultConfig()
+	config.DBPath = copyDatabase(t)
+	config.ReadOnly = false
+	store, err := OpenStore(config.storeOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	server := NewServer(store, config)
+
+	rec := sendJSON(server, http.MethodPost, "/playlists/9/tracks/1", "")
+	var playlist map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &playlist); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || playlist["TrackCount"] != 2.0 ||
+		playlist["Milliseconds"] != 638013.0 {
+		t.Errorf("add returned %v: %v", rec.Code, playlist)
+	}
+
+	rec = sendJSON(server, http.MethodDelete, "/playlists/9/tracks/1", "")
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("remove returned wrong status code: \n\ngot\n\n%v\n\nwant"+
+			"\n\n%v", rec.Code, http.StatusNoContent)
+	}
+
+	bad := []struct {
+		method string
+		target string
+		status int
+		code   string
+	}{
+		{http.MethodPost, "/playlists/9/tracks/3402", http.StatusConflict,
+			"playlist_track_exists"},
+		{http.MethodPost, "/playlists/9/tracks/99999", http.StatusNotFound,
+			"not_found"},
+		{http.MethodPost, "/playlists/99/tracks/1", http.StatusNotFound,
+			"not_found"},
+		{http.MethodDelete, "/playlists/9/tracks/1", http.StatusNotFound,
+			"not_found"},
+		{http.MethodPut, "/playlists/9/tracks/1",
+			http.StatusMethodNotAllowed, "method_not_allowed"},
+	}
+	for _, test := range bad {
+		rec := sendJSON(server, test.method, test.target, "")
+		if rec.Code != test.status {
+			t.Errorf("%s %s returned wrong status code: \n\ngot\n\n%v\n\nwant"+
+				"\n\n%v", test.method, test.target, rec.Code, test.status)
+		}
+		ResponseErrorTest(rec, test.code, "", t)
+	}
+
+	rec = sendJSON(testServer, http.MethodPost, "/playlists/9/tracks/1", "")
+	ResponseErrorTest(rec, "read_only", "", t)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"strings"
+)
+
+// Columns selected for a track query when no fields are chosen, in the
+// order they are scanned into the Track struct
+var trackColumns = fieldColumns(trackFields)
+
+// Tables joined to the track table so artist and album names are available
+const trackFrom = "FROM track " +
+	"INNER JOIN album ON track.AlbumId = album.AlbumId " +
+	"INNER JOIN artist ON album.ArtistId = artist.ArtistId"
+
+// Replacer used to escape LIKE wildcards so they match literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Function to escape a user supplied string for use in a LIKE pattern
+// Patterns built from the result must use ESCAPE '\'
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// queryBuilder composes a SELECT statement from clauses that each carry
+// their own bound arguments, so no user input is ever written into the SQL
+type queryBuilder struct {
+	columns    []string
+	selectArgs []interface{}
+	from       string
+	joins      []string
+	joinArgs   []interface{}
+	where      []string
+	whereArgs  []interface{}
+	group      []string
+	order      []string
+	orderArgs  []interface{}
+	limit      int
+	offset     int
+	hasLimit   bool
+	hasOffset  bool
+}
+
+// Function to create a query builder for the track search
+func newTrackQuery() *queryBuilder {
+	return &queryBuilder{columns: trackColumns, from: trackFrom}
+}
+
+// Function to create a query builder for the track search selecting only
+// the columns of some fields
+func newFieldQuery(fields []trackField) *queryBuilder {
+	return &queryBuilder{columns: fieldColumns(fields), from: trackFrom}
+}
+
+// Select adds a column after the existing columns
+func (q *queryBuilder) Select(column string, args ...interface{}) *queryBuilder {
+	// Copy so the shared column lists are never appended to
+	q.columns = append(q.columns[:len(q.columns):len(q.columns)], column)
+	q.selectArgs = append(q.selectArgs, args...)
+	return q
+}
+
+// Join adds a join clause after the tables in from
+func (q *queryBuilder) Join(clause string, args ...interface{}) *queryBuilder {
+	q.joins = append(q.joins, clause)
+	q.joinArgs = append(q.joinArgs, args...)
+	return q
+}
+
+// Where adds a condition joined to any existing conditions with AND
+func (q *queryBuilder) Where(clause string, args ...interface{}) *queryBuilder {
+	q.where = append(q.where, clause)
+	q.whereArgs = append(q.whereArgs, args...)
+	return q
+}
+
+// GroupBy adds a grouping term after any existing grouping terms
+func (q *queryBuilder) GroupBy(clause string) *queryBuilder {
+	q.group = append(q.group, clause)
+	return q
+}
+
+// OrderBy adds a sort term after any existing sort terms
+func (q *queryBuilder) OrderBy(clause string, args ...interface{}) *queryBuilder {
+	q.order = append(q.order, clause)
+	q.orderArgs = append(q.orderArgs, args...)
+	return q
+}
+
+// ClearOrder removes every sort term
+func (q *queryBuilder) ClearOrder() *queryBuilder {
+	q.order, q.orderArgs = nil, nil
+	return q
+}
+
+// Limit sets the maximum number of rows returned
+func (q *queryBuilder) Limit(n int) *queryBuilder {
+	q.limit = n
+	q.hasLimit = true
+	return q
+}
+
+// Offset sets the number of rows skipped
+func (q *queryBuilder) Offset(n int) *queryBuilder {
+	q.offset = n
+	q.hasOffset = true
+	return q
+}
+
+// Function to write the joins and WHERE clause shared by every query built
+// from q
+func (q *queryBuilder) writeWhere(sb *strings.Builder) {
+	for _, join := range q.joins {
+		sb.WriteString(" ")
+		sb.WriteString(join)
+	}
+	if len(q.where) > 0 {
+		sb.WriteString(" WHERE ")
+		sb.WriteString(strings.Join(q.where, " AND "))
+	}
+}
+
+// Build returns the SQL statement and the arguments to bind to it
+func (q *queryBuilder) Build() (string, []interface{}) {
+	var sb strings.Builder
+	args := make([]interface{}, 0, len(q.selectArgs)+len(q.joinArgs)+
+		len(q.whereArgs)+len(q.orderArgs)+2)
+
+	sb.WriteString("SELECT ")
+	sb.WriteString(strings.Join(q.columns, ", "))
+	sb.WriteString(" ")
+	sb.WriteString(q.from)
+	args = append(args, q.selectArgs...)
+
+	q.writeWhere(&sb)
+	args = append(args, q.joinArgs...)
+	args = append(args, q.whereArgs...)
+
+	if len(q.group) > 0 {
+		sb.WriteString(" GROUP BY ")
+		sb.WriteString(strings.Join(q.group, ", "))
+	}
+	if len(q.order) > 0 {
+		sb.WriteString(" ORDER BY ")
+		sb.WriteString(strings.Join(q.order, ", "))
+		args = append(args, q.orderArgs...)
+	}
+
+	// SQLite only accepts an offset after a limit, -1 means no limit
+	if q.hasLimit {
+		sb.WriteString(" LIMIT ?")
+		args = append(args, q.limit)
+	} else if q.hasOffset {
+		sb.WriteString(" LIMIT -1")
+	}
+	if q.hasOffset {
+		sb.WriteString(" OFFSET ?")
+		args = append(args, q.offset)
+	}
+	return sb.String(), args
+}
+
+// BuildCount returns a statement counting every row matched by the joins
+// and WHERE clause of q, ignoring its order, limit and offset
+func (q *queryBuilder) BuildCount() (string, []interface{}) {
+	var sb strings.Builder
+	sb.WriteString("SELECT COUNT(*) ")
+	sb.WriteString(q.from)
+	q.writeWhere(&sb)
+	args := append(append([]interface{}(nil), q.joinArgs...), q.whereArgs...)
+	return sb.String(), args
+}
+
+// Relevance rank of a track for a search, bound to the search term and
+// the escaped prefix pattern
+// Exact matches rank first, then prefix matches, then all other matches
+const rankExpr = `(CASE WHEN track.Name = ? THEN 1 ` +
+	`WHEN track.Name LIKE ? ESCAPE '\' THEN 2 ELSE 3 END)`
+
+// Function to get the arguments bound to rankExpr for a search
+func rankArgs(search string) []interface{} {
+	return []interface{}{search, escapeLike(search) + "%"}
+}
+
+// Function to compute the rankExpr value of a track name in Go
+// SQLite's LIKE folds case for ASCII letters only, so the same is done here
+func searchRank(name string, search string) int {
+	if name == search {
+		return 1
+	}
+	if len(name) >= len(search) && asciiEqualFold(name[:len(search)], search) {
+		return 2
+	}
+	return 3
+}
+
+// Function to compare two strings ignoring the case of ASCII letters only
+func asciiEqualFold(a string, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		ca, cb := a[i], b[i]
+		if 'A' <= ca && ca <= 'Z' {
+			ca += 'a' - 'A'
+		}
+		if 'A' <= cb && cb <= 'Z' {
+			cb += 'a' - 'A'
+		}
+		if ca != cb {
+			return false
+		}
+	}
+	return true
+}
+
+// Function to add the track name search and relevance ordering to a query
+// TrackId breaks ties between tracks with the same name so the order is
+// deterministic
+func searchTracks(q *queryBuilder, search string) *queryBuilder {
+	q.Where(`track.Name LIKE ? ESCAPE '\'`, "%"+escapeLike(search)+"%")
+	q.OrderBy(rankExpr, rankArgs(search)...)
+	q.OrderBy("track.Name")
+	q.OrderBy("track.TrackId")
+	return q
+}
+
+// Function to restrict a search to the tracks that come after a cursor in
+// the search ordering
+func afterCursor(q *queryBuilder, search string, c cursor) *queryBuilder {
+	args := append(rankArgs(search), c.Rank, c.Name, c.TrackId)
+	return q.Where("("+rankExpr+", track.Name, track.TrackId) > (?, ?, ?)",
+		args...)
+}
+
+// Function to order a query listing every track by TrackId
+func browseTracks(q *queryBuilder) *queryBuilder {
+	return q.OrderBy("track.TrackId")
+}
+
+// Function to restrict a track listing to the tracks after a cursor
+func afterTrack(q *queryBuilder, c cursor) *queryBuilder {
+	return q.Where("track.TrackId > ?", c.TrackId)
+}
+
+// Function to list the SQL for each shape of track search so the statements
+// can be prepared at startup
+func searchQueries() []string {
+	plain, _ := searchTracks(newTrackQuery(), "").Build()
+	limited, _ := searchTracks(newTrackQuery(), "").Limit(0).Build()
+	paged, _ := searchTracks(newTrackQuery(), "").Limit(0).Offset(0).Build()
+	count, _ := searchTracks(newTrackQuery(), "").BuildCount()
+	keyset, _ := afterCursor(searchTracks(newTrackQuery(), ""), "",
+		cursor{}).Limit(0).Build()
+	browse, _ := browseTracks(newTrackQuery()).Limit(0).Build()
+	browsePaged, _ := browseTracks(newTrackQuery()).Limit(0).Offset(0).Build()
+	browseCount, _ := browseTracks(newTrackQuery()).BuildCount()
+	browseKeyset, _ := afterTrack(browseTracks(newTrackQuery()),
+		cursor{}).Limit(0).Build()
+	return []string{plain, limited, paged, count, keyset, browse, browsePaged,
+		browseCount, browseKeyset}
+}
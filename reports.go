@@ -0,0 +1,265 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Sales are the units sold of a track, artist or genre and the revenue
+// from them
+type Sales struct {
+	Quantity int64   `json:"Quantity"`
+	Revenue  float64 `json:"Revenue"`
+}
+
+// TrackSales is a row of the top-selling tracks report
+type TrackSales struct {
+	TrackId NullInt64  `json:"TrackId"`
+	Name    NullString `json:"Name"`
+	Artist  NullString `json:"Artist"`
+	Sales
+}
+
+// ArtistSales is a row of the top-selling artists report
+type ArtistSales struct {
+	ArtistId NullInt64  `json:"ArtistId"`
+	Name     NullString `json:"Name"`
+	Sales
+}
+
+// GenreSales is a row of the top-selling genres report
+type GenreSales struct {
+	GenreId NullInt64  `json:"GenreId"`
+	Name    NullString `json:"Name"`
+	Sales
+}
+
+// Revenue is a row of the revenue report, Country and Month are only set
+// when grouped by
+type Revenue struct {
+	Country  string  `json:"Country,omitempty"`
+	Month    string  `json:"Month,omitempty"`
+	Invoices int64   `json:"Invoices"`
+	Revenue  float64 `json:"Revenue"`
+}
+
+// Layout of the from and to parameters
+const reportDate = "2006-01-02"
+
+// Number of rows in a top-selling report when no limit is given
+const defaultReportLimit = 10
+
+// Tables joined so each invoice line has its invoice and track
+const salesFrom = "FROM invoiceline " +
+	"INNER JOIN invoice ON invoiceline.InvoiceId = invoice.InvoiceId " +
+	"INNER JOIN track ON invoiceline.TrackId = track.TrackId"
+
+// Columns totalling the invoice lines of a group
+// Revenue is rounded to cents so sums of prices are not written as
+// 0.9900000000000001
+const (
+	salesQuantity = "SUM(invoiceline.Quantity)"
+	salesRevenue  = "ROUND(SUM(invoiceline.UnitPrice * invoiceline.Quantity), 2)"
+)
+
+// Columns totalling the sales of each track, for the sales expansion
+const (
+	trackSalesQuantity = "(SELECT COALESCE(SUM(invoiceline.Quantity), 0) " +
+		"FROM invoiceline WHERE invoiceline.TrackId = track.TrackId)"
+	trackSalesRevenue = "(SELECT ROUND(COALESCE(SUM(" +
+		"invoiceline.UnitPrice * invoiceline.Quantity), 0), 2) " +
+		"FROM invoiceline WHERE invoiceline.TrackId = track.TrackId)"
+)
+
+// salesReport ranks the groups of invoice lines that sold the most units
+// The columns name each group and are scanned before its Sales by scan
+type salesReport struct {
+	columns []string
+	joins   []string
+	group   string
+	scan    func(rows *sql.Rows) (interface{}, error)
+}
+
+// Top-selling reports by path
+var salesReports = map[string]salesReport{
+	"/reports/top-tracks": {
+		[]string{"track.TrackId", "track.Name", "artist.Name"},
+		[]string{"INNER JOIN album ON track.AlbumId = album.AlbumId",
+			"INNER JOIN artist ON album.ArtistId = artist.ArtistId"},
+		"track.TrackId",
+		func(rows *sql.Rows) (interface{}, error) {
+			var t TrackSales
+			err := rows.Scan(&t.TrackId, &t.Name, &t.Artist, &t.Quantity,
+				&t.Revenue)
+			return &t, err
+		}},
+	"/reports/top-artists": {
+		[]string{"artist.ArtistId", "artist.Name"},
+		[]string{"INNER JOIN album ON track.AlbumId = album.AlbumId",
+			"INNER JOIN artist ON album.ArtistId = artist.ArtistId"},
+		"artist.ArtistId",
+		func(rows *sql.Rows) (interface{}, error) {
+			var a ArtistSales
+			err := rows.Scan(&a.ArtistId, &a.Name, &a.Quantity, &a.Revenue)
+			return &a, err
+		}},
+	"/reports/top-genres": {
+		[]string{"genre.GenreId", "genre.Name"},
+		[]string{"INNER JOIN genre ON track.GenreId = genre.GenreId"},
+		"genre.GenreId",
+		func(rows *sql.Rows) (interface{}, error) {
+			var g GenreSales
+			err := rows.Scan(&g.GenreId, &g.Name, &g.Quantity, &g.Revenue)
+			return &g, err
+		}},
+}
+
+// Groupings of the revenue report by group_by name
+var revenueGroups = map[string]string{
+	"country": "COALESCE(invoice.BillingCountry, '')",
+	"month":   "substr(invoice.InvoiceDate, 1, 7)",
+}
+
+// Function to read and validate the date range and limit of a report
+// The range includes both days and either end may be left out. The limit
+// is only read when limited is set
+func (s *Server) parseReportParams(values url.Values,
+	limited bool) (from string, to string, limit int, e *apiError) {
+	var dates [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		value := values.Get(name)
+		if value == "" {
+			continue
+		}
+		date, err := time.Parse(reportDate, value)
+		if err != nil {
+			e := errInvalidDate.withMessage(
+				"%s must be a date in the form YYYY-MM-DD, got %q", name, value)
+			e.Field = name
+			return "", "", 0, &e
+		}
+		dates[i] = date
+	}
+	if !dates[0].IsZero() {
+		from = dates[0].Format(reportDate)
+	}
+	if !dates[1].IsZero() {
+		// Invoice dates have a time, so the range ends before the next day
+		to = dates[1].AddDate(0, 0, 1).Format(reportDate)
+	}
+	if from != "" && to != "" && dates[0].After(dates[1]) {
+		e := errInvalidDate.withMessage("from %s is after to %s",
+			values.Get("from"), values.Get("to"))
+		e.Field = "from"
+		return "", "", 0, &e
+	}
+
+	if !limited {
+		return from, to, 0, nil
+	}
+	limit, _, e = s.parseLimit(values, defaultReportLimit)
+	if e != nil {
+		return "", "", 0, e
+	}
+	return from, to, limit, nil
+}
+
+// Function to limit a report to the invoices in a date range
+func applyDateRange(q *queryBuilder, from string, to string) *queryBuilder {
+	if from != "" {
+		q.Where("invoice.InvoiceDate >= ?", from)
+	}
+	if to != "" {
+		q.Where("invoice.InvoiceDate < ?", to)
+	}
+	return q
+}
+
+// Function to create the request handler function for a top-selling
+// report, which accepts from, to and limit parameters
+func (s *Server) salesReportHandler(report salesReport) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.serveSalesReport(w, r, report)
+	}
+}
+
+// Function to serve a top-selling tracks, artists or genres report
+func (s *Server) serveSalesReport(w http.ResponseWriter, r *http.Request,
+	report salesReport) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	from, to, limit, e := s.parseReportParams(r.URL.Query(), true)
+	if e != nil {
+		errorHandler(w, r, *e)
+		return
+	}
+
+	q := &queryBuilder{columns: report.columns, from: salesFrom}
+	q.Select(salesQuantity + " AS quantity").Select(salesRevenue + " AS revenue")
+	for _, join := range report.joins {
+		q.Join(join)
+	}
+	applyDateRange(q, from, to).GroupBy(report.group)
+	q.OrderBy("quantity DESC").OrderBy("revenue DESC").OrderBy(report.group)
+	query, args := q.Limit(limit).Build()
+	s.serveRows(w, r, query, args, report.scan)
+}
+
+// Request handler function for the revenue of invoices grouped by billing
+// country, month or both
+// Accepts from, to and group_by parameters, groups are ordered by the
+// groupings in the order given
+func (s *Server) revenueHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	values := r.URL.Query()
+	from, to, _, e := s.parseReportParams(values, false)
+	if e != nil {
+		errorHandler(w, r, *e)
+		return
+	}
+	groups := []string{"month"}
+	if value := values.Get("group_by"); value != "" {
+		groups = strings.Split(value, ",")
+	}
+	chosen := make(map[string]bool)
+	for i, name := range groups {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := revenueGroups[name]; !ok || chosen[name] {
+			e := errInvalidGroupBy.withMessage(
+				"group_by must be country, month or both, got %q",
+				values.Get("group_by"))
+			errorHandler(w, r, e)
+			return
+		}
+		groups[i], chosen[name] = name, true
+	}
+
+	// Groupings not chosen are selected as empty so every row scans alike
+	q := &queryBuilder{from: "FROM invoice"}
+	for _, name := range []string{"country", "month"} {
+		if chosen[name] {
+			q.Select(revenueGroups[name])
+		} else {
+			q.Select("''")
+		}
+	}
+	q.Select("COUNT(*)").Select("ROUND(SUM(invoice.Total), 2)")
+	applyDateRange(q, from, to)
+	for _, name := range groups {
+		q.GroupBy(revenueGroups[name]).OrderBy(revenueGroups[name])
+	}
+	query, args := q.Build()
+	s.serveRows(w, r, query, args, func(rows *sql.Rows) (interface{}, error) {
+		var v Revenue
+		err := rows.Scan(&v.Country, &v.Month, &v.Invoices, &v.Revenue)
+		return &v, err
+	})
+}
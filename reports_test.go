@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSalesReports(t *testing.T) {
+	tests := []struct {
+		url  string
+		want interface{}
+	}{
+		{"/reports/top-artists?limit=2&from=2010-01-01&to=2010-12-31",
+			[]interface{}{
+				map[string]interface{}{"ArtistId": 90.0, "Name": "Iron Maiden",
+					"Quantity": 35.0, "Revenue": 34.65},
+				map[string]interface{}{"ArtistId": 150.0, "Name": "U2",
+					"Quantity": 27.0, "Revenue": 26.73}}},
+		{"/reports/top-genres?limit=1", []interface{}{
+			map[string]interface{}{"GenreId": 1.0, "Name": "Rock",
+				"Quantity": 835.0, "Revenue": 826.65}}},
+		{"/reports/top-tracks?limit=1", []interface{}{
+			map[string]interface{}{"TrackId": 2832.0, "Name": "The Woman King",
+				"Artist": "Battlestar Galactica", "Quantity": 2.0,
+				"Revenue": 3.98}}},
+		// The to date includes the whole day
+		{"/reports/revenue?from=2009-01-01&to=2009-02-01", []interface{}{
+			map[string]interface{}{"Month": "2009-01", "Invoices": 6.0,
+				"Revenue": 35.64},
+			map[string]interface{}{"Month": "2009-02", "Invoices": 2.0,
+				"Revenue": 3.96}}},
+		{"/reports/revenue?group_by=month,country&from=2009-01-01" +
+			"&to=2009-01-02", []interface{}{
+			map[string]interface{}{"Country": "Germany", "Month": "2009-01",
+				"Invoices": 1.0, "Revenue": 1.98},
+			map[string]interface{}{"Country": "Norway", "Month": "2009-01",
+				"Invoices": 1.0, "Revenue": 3.96}}},
+		{"/reports/top-tracks?from=2030-01-01", []interface{}{}},
+		// The router ignores a trailing slash
+		{"/reports/top-genres/?limit=1&to=2009-01-01", []interface{}{
+			map[string]interface{}{"GenreId": 1.0, "Name": "Rock",
+				"Quantity": 2.0, "Revenue": 1.98}}},
+		{"/tracks/2?fields=Name&expand=sales", map[string]interface{}{
+			"Name":  "Balls to the Wall",
+			"Sales": map[string]interface{}{"Quantity": 2.0, "Revenue": 1.98}}},
+		// Expanded sales are all-time, the report dates do not apply
+		{"/tracks/2?fields=Name&expand=sales&from=2030-01-01&to=2030-12-31",
+			map[string]interface{}{"Name": "Balls to the Wall",
+				"Sales": map[string]interface{}{"Quantity": 2.0,
+					"Revenue": 1.98}}},
+	}
+	for _, test := range tests {
+		if got := getJSON(t, test.url, http.StatusOK); !reflect.DeepEqual(got,
+			test.want) {
+			t.Errorf("%s returned wrong body: \n\ngot\n\n%v\n\nwant\n\n%v",
+				test.url, got, test.want)
+		}
+	}
+
+	// Revenue by country adds up to the revenue of every invoice
+	countries, _ := getJSON(t, "/reports/revenue?group_by=country",
+		http.StatusOK).([]interface{})
+	total := 0.0
+	for _, row := range countries {
+		total += row.(map[string]interface{})["Revenue"].(float64)
+	}
+	if len(countries) != 24 || total < 2328.59 || total > 2328.61 {
+		t.Errorf("wrong revenue by country: \n\ngot\n\n%v countries, %v"+
+			"\n\nwant\n\n24 countries, 2328.6", len(countries), total)
+	}
+}
+
+func TestSalesReportErrors(t *testing.T) {
+	tests := []struct {
+		url   string
+		code  string
+		field string
+	}{
+		{"/reports/top-tracks?from=2010-13-01", "invalid_date", "from"},
+		{"/reports/top-artists?to=yesterday", "invalid_date", "to"},
+		{"/reports/top-genres?from=2011-01-01&to=2010-12-31", "invalid_date",
+			"from"},
+		{"/reports/top-tracks?limit=0", "invalid_limit", "limit"},
+		{"/reports/revenue?group_by=year", "invalid_group_by", "group_by"},
+		{"/reports/revenue?group_by=month,month", "invalid_group_by",
+			"group_by"},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		testServer.routes().ServeHTTP(rec,
+			httptest.NewRequest(http.MethodGet, test.url, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s returned wrong status code: \n\ngot\n\n%v\n\nwant"+
+				"\n\n%v", test.url, rec.Code, http.StatusBadRequest)
+		}
+		ResponseErrorTest(rec, test.code, test.field, t)
+	}
+}
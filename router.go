@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// route is a path pattern such as "/tracks/{id}" and its handler
+// A segment in braces matches any single segment of the path
+type route struct {
+	segments []string
+	handler  http.HandlerFunc
+}
+
+// router sends each request to the first route matching its path, and
+// answers every other path with a 404 error
+// Handlers check the method themselves so they can list the allowed
+// methods in a 405 error
+type router struct {
+	routes []route
+}
+
+// Function to split a path into its segments, ignoring a trailing slash
+func pathSegments(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
+
+// HandleFunc registers a handler for a path pattern
+func (rt *router) HandleFunc(pattern string, handler http.HandlerFunc) {
+	rt.routes = append(rt.routes, route{pathSegments(pattern), handler})
+}
+
+// Function to match a path against a route, returning the values of the
+// segments in braces
+func (rt route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(rt.segments) {
+		return nil, false
+	}
+	var params map[string]string
+	for i, segment := range rt.segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[segment[1:len(segment)-1]] = segments[i]
+		} else if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// ServeHTTP implements http.Handler
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := pathSegments(r.URL.Path)
+	for _, route := range rt.routes {
+		params, ok := route.match(segments)
+		if !ok {
+			continue
+		}
+		if params != nil {
+			r = r.WithContext(context.WithValue(r.Context(), pathParamsKey,
+				params))
+		}
+		route.handler(w, r)
+		return
+	}
+	e := errNotFound.withMessage("No resource at %s", r.URL.Path)
+	errorHandler(w, r, e)
+}
+
+// Function to get a parameter matched from the request path
+func pathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(pathParamsKey).(map[string]string)
+	return params[name]
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	var matched, id string
+	rt := &router{}
+	rt.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		matched = "root"
+	})
+	rt.HandleFunc("/albums/{id}/tracks", func(w http.ResponseWriter,
+		r *http.Request) {
+		matched, id = "album tracks", pathParam(r, "id")
+	})
+
+	tests := []struct {
+		path    string
+		matched string
+		id      string
+		status  int
+	}{
+		{"/", "root", "", http.StatusOK},
+		{"/albums/7/tracks", "album tracks", "7", http.StatusOK},
+		{"/albums/7/tracks/", "album tracks", "7", http.StatusOK},
+		{"/albums/7", "", "", http.StatusNotFound},
+		{"/albums/7/tracks/1", "", "", http.StatusNotFound},
+		{"/search", "", "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		matched, id = "", ""
+		rec := httptest.NewRecorder()
+		rt.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if matched != test.matched || id != test.id || rec.Code != test.status {
+			t.Errorf("%s: \n\ngot\n\n%q %q %v\n\nwant\n\n%q %q %v", test.path,
+				matched, id, rec.Code, test.matched, test.id, test.status)
+		}
+		if rec.Code == http.StatusNotFound {
+			ResponseErrorTest(rec, "not_found", "", t)
+		}
+	}
+}
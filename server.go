@@ -6,15 +6,15 @@ Written by Noah Ueffing
 package main
 
 import (
-	"log"
-	"os"
-	"fmt"
-	"net/http"
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding"
 	"encoding/json"
-	"strings"
+	"log"
+	"net/http"
+	"os"
 	"strconv"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 // struct used for converting track data to json form
@@ -31,6 +31,15 @@ type Track struct {
 	Milliseconds NullInt64 `json:"Milliseconds"`
 	Bytes NullInt64 `json:"Bytes"`
 	UnitPrice NullFloat64 `json:"UnitPrice"`
+	// Score is only set for fuzzy searches, 1 is a perfect match
+	Score *float64 `json:"Score,omitempty"`
+
+	// Only read to build expanded objects, see expand.go
+	GenreName     NullString  `json:"-"`
+	MediaTypeName NullString  `json:"-"`
+	ArtistId      NullInt64   `json:"-"`
+	SalesQuantity NullInt64   `json:"-"`
+	SalesRevenue  NullFloat64 `json:"-"`
 }
 
 // NullString is an alias for sql.NullString data type
@@ -38,18 +47,42 @@ type NullString struct {
 	sql.NullString
 }
 
-// NullInt64 is an alias for sql.NullString data type
+// NullInt64 is an alias for sql.NullInt64 data type
 type NullInt64 struct {
 	sql.NullInt64
 }
 
-// NullFloat64 is an alias for sql.NullString data type
+// NullFloat64 is an alias for sql.NullFloat64 data type
 type NullFloat64 struct {
 	sql.NullFloat64
 }
 
+// The wrappers read and write their sql types unchanged, and can be used
+// in JSON bodies and as text such as query parameters
+var (
+	_ sql.Scanner              = (*NullString)(nil)
+	_ driver.Valuer            = NullString{}
+	_ json.Marshaler           = NullString{}
+	_ json.Unmarshaler         = (*NullString)(nil)
+	_ encoding.TextMarshaler   = NullString{}
+	_ encoding.TextUnmarshaler = (*NullString)(nil)
+	_ sql.Scanner              = (*NullInt64)(nil)
+	_ driver.Valuer            = NullInt64{}
+	_ json.Marshaler           = NullInt64{}
+	_ json.Unmarshaler         = (*NullInt64)(nil)
+	_ encoding.TextMarshaler   = NullInt64{}
+	_ encoding.TextUnmarshaler = (*NullInt64)(nil)
+	_ sql.Scanner              = (*NullFloat64)(nil)
+	_ driver.Valuer            = NullFloat64{}
+	_ json.Marshaler           = NullFloat64{}
+	_ json.Unmarshaler         = (*NullFloat64)(nil)
+	_ encoding.TextMarshaler   = NullFloat64{}
+	_ encoding.TextUnmarshaler = (*NullFloat64)(nil)
+)
+
 // MarshalJSON for NullString
-func (ns *NullString) MarshalJSON() ([]byte, error) {
+// Value receivers let Track values be encoded as well as pointers
+func (ns NullString) MarshalJSON() ([]byte, error) {
 	if !ns.Valid {
 		return []byte("null"), nil
 	}
@@ -57,7 +90,7 @@ func (ns *NullString) MarshalJSON() ([]byte, error) {
 }
 
 // MarshalJSON for NullInt64
-func (ni *NullInt64) MarshalJSON() ([]byte, error) {
+func (ni NullInt64) MarshalJSON() ([]byte, error) {
 	if !ni.Valid {
 		return []byte("null"), nil
 	}
@@ -65,187 +98,462 @@ func (ni *NullInt64) MarshalJSON() ([]byte, error) {
 }
 
 // MarshalJSON for NullFloat64
-func (nf *NullFloat64) MarshalJSON() ([]byte, error) {
+func (nf NullFloat64) MarshalJSON() ([]byte, error) {
 	if !nf.Valid {
 		return []byte("null"), nil
 	}
 	return json.Marshal(nf.Float64)
 }
 
-// Function to send http error response and print error message to log
-func errorHandler(w http.ResponseWriter, status int, message string) {
-	w.WriteHeader(status)
-	log.Println(message)
+// UnmarshalJSON for NullString, null is read as an invalid NullString
+func (ns *NullString) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		ns.String, ns.Valid = "", false
+		return nil
+	}
+	if err := json.Unmarshal(data, &ns.String); err != nil {
+		return err
+	}
+	ns.Valid = true
+	return nil
+}
+
+// UnmarshalJSON for NullInt64, null is read as an invalid NullInt64
+func (ni *NullInt64) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		ni.Int64, ni.Valid = 0, false
+		return nil
+	}
+	if err := json.Unmarshal(data, &ni.Int64); err != nil {
+		return err
+	}
+	ni.Valid = true
+	return nil
+}
+
+// UnmarshalJSON for NullFloat64, null is read as an invalid NullFloat64
+func (nf *NullFloat64) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		nf.Float64, nf.Valid = 0, false
+		return nil
+	}
+	if err := json.Unmarshal(data, &nf.Float64); err != nil {
+		return err
+	}
+	nf.Valid = true
+	return nil
+}
+
+// MarshalText for NullString, an invalid NullString is empty text
+func (ns NullString) MarshalText() ([]byte, error) {
+	if !ns.Valid {
+		return []byte{}, nil
+	}
+	return []byte(ns.String), nil
+}
+
+// MarshalText for NullInt64, an invalid NullInt64 is empty text
+func (ni NullInt64) MarshalText() ([]byte, error) {
+	if !ni.Valid {
+		return []byte{}, nil
+	}
+	return []byte(strconv.FormatInt(ni.Int64, 10)), nil
+}
+
+// MarshalText for NullFloat64, an invalid NullFloat64 is empty text
+func (nf NullFloat64) MarshalText() ([]byte, error) {
+	if !nf.Valid {
+		return []byte{}, nil
+	}
+	return []byte(strconv.FormatFloat(nf.Float64, 'g', -1, 64)), nil
+}
+
+// UnmarshalText for NullString, empty text is read as an invalid
+// NullString so a parameter given without a value is treated as missing
+func (ns *NullString) UnmarshalText(text []byte) error {
+	ns.String, ns.Valid = string(text), len(text) > 0
+	return nil
+}
+
+// UnmarshalText for NullInt64, empty text is read as an invalid NullInt64
+func (ni *NullInt64) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		ni.Int64, ni.Valid = 0, false
+		return nil
+	}
+	n, err := strconv.ParseInt(string(text), 10, 64)
+	if err != nil {
+		return err
+	}
+	ni.Int64, ni.Valid = n, true
+	return nil
+}
+
+// UnmarshalText for NullFloat64, empty text is read as an invalid
+// NullFloat64
+func (nf *NullFloat64) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		nf.Float64, nf.Valid = 0, false
+		return nil
+	}
+	f, err := strconv.ParseFloat(string(text), 64)
+	if err != nil {
+		return err
+	}
+	nf.Float64, nf.Valid = f, true
+	return nil
+}
+
+// Scan implements sql.Scanner by passing through to sql.NullString
+func (ns *NullString) Scan(value interface{}) error {
+	return ns.NullString.Scan(value)
+}
+
+// Value implements driver.Valuer by passing through to sql.NullString
+func (ns NullString) Value() (driver.Value, error) {
+	return ns.NullString.Value()
+}
+
+// Scan implements sql.Scanner by passing through to sql.NullInt64
+func (ni *NullInt64) Scan(value interface{}) error {
+	return ni.NullInt64.Scan(value)
+}
+
+// Value implements driver.Valuer by passing through to sql.NullInt64
+func (ni NullInt64) Value() (driver.Value, error) {
+	return ni.NullInt64.Value()
+}
+
+// Scan implements sql.Scanner by passing through to sql.NullFloat64
+func (nf *NullFloat64) Scan(value interface{}) error {
+	return nf.NullFloat64.Scan(value)
+}
+
+// Value implements driver.Valuer by passing through to sql.NullFloat64
+func (nf NullFloat64) Value() (driver.Value, error) {
+	return nf.NullFloat64.Value()
+}
+
+// Server holds the dependencies shared by the request handlers
+type Server struct {
+	store        *Store
+	config       Config
+	cursorSecret []byte
+	cacheRules   []cacheRule
+}
+
+// NewServer creates a server that answers requests from the given store
+// Without a configured cursor secret a random one is used, so cursors only
+// remain valid until the server restarts
+func NewServer(store *Store, config Config) *Server {
+	secret := []byte(config.CursorSecret)
+	if len(secret) == 0 {
+		secret = newCursorSecret()
+	}
+	// The rules are checked by Config.Validate
+	rules, _ := parseCacheRules(config.CacheControl)
+	return &Server{store: store, config: config, cursorSecret: secret,
+		cacheRules: rules}
 }
 
 // Request handler function for search queries
-func handler(w http.ResponseWriter, r *http.Request) {
-	// Set log ouput to Stdout
-	log.SetOutput(os.Stdout)
+func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
+	s.serveTracks(w, r, true)
+}
 
+// Request handler function for listing tracks, searching them when a
+// search is given
+func (s *Server) tracksHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.createTrack(w, r)
+	case http.MethodGet:
+		s.serveTracks(w, r, false)
+	default:
+		methodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+	}
+}
+
+// Function to search or browse tracks and write them in the format the
+// client accepts
+// Scope filters are always applied, limiting the tracks to one resource
+// such as an album
+func (s *Server) serveTracks(w http.ResponseWriter, r *http.Request,
+	searchRequired bool, scope ...filterValue) {
 	// Make sure the request is a GET request, otherwise give error
 	if r.Method != http.MethodGet {
-		errorHandler(w, http.StatusMethodNotAllowed, 
-			"405 Error: Method not allowed")
+		methodNotAllowed(w, r, http.MethodGet)
 		return
 	}
-	// Read the URL for parameters
-	searchTerms, ok := r.URL.Query()["search"]
-	inputLimit := r.URL.Query()["limit"]
-	inputOffset := r.URL.Query()["offset"]
-
-	// Error checking for search parameter
-	if !ok {
-		errorHandler(w, http.StatusBadRequest, "400 Error: Bad request")
+	// Read and validate the URL parameters
+	params, perr := s.parseSearchParams(r, searchRequired)
+	if perr != nil {
+		errorHandler(w, r, *perr)
 		return
 	}
-
-	// If search parameter is empty, give error
-	if len(searchTerms[0]) < 1 {
-		errorHandler(w, http.StatusBadRequest, 
-			"400 Error: No valid search criteria")
+	// Choose the output format, only JSON can be wrapped in the envelope
+	w.Header().Add("Vary", "Accept")
+	format, ferr := negotiateFormat(r)
+	if ferr != nil {
+		errorHandler(w, r, *ferr)
 		return
 	}
-
-	// Query()["search"] will return an array of parameters, 
-	// we only want a single parameter
-	search := searchTerms[0]
-	var limit string
-	var offset string
-	if len(inputLimit) > 0 {
-		limit = inputLimit[0]
+	if params.Envelope && format.name != trackFormats[0].name {
+		errorHandler(w, r, errInvalidEnvelope.withMessage(
+			"The envelope is only available with format=%s",
+			trackFormats[0].name))
+		return
+	}
+	// Skip the search if the client already has the results
+	if writeNotModified(w) {
+		return
 	}
-	if len(inputOffset) > 0 {
-		offset = inputOffset[0]
+	search := params.Search
+	received := "Received search query for: " + search
+	completed := "Search query completed for: " + search
+	if params.Mode == modeBrowse {
+		received, completed = "Received track listing", "Track listing completed"
 	}
-	
-	// Replace ' with '' for SQL query functionality
-	search = strings.Replace(search, "'", "''", -1) 
 
 	// log the recieved search query
-	log.Println("Received search query for: " + string(search))
+	logAt(levelInfo, received)
 
-	// Open the database connection
-	db, err := sql.Open("sqlite3", "./Chinook_Sqlite.sqlite")
-	if err != nil {
-		errorHandler(w, http.StatusInternalServerError, 
-			"500 Error: Database connection error")
-		return
+	// Build the search query, all user input is bound as parameters
+	scan := expandScanFields(scanFields(params.Fields), params.Expand)
+	q := newFieldQuery(scan)
+	applyExpansions(q, params.Expand)
+	switch params.Mode {
+	case modeFullText:
+		searchFullText(q, params.Match)
+	case modeFuzzy:
+		searchFuzzy(q, params.Match, s.config.FuzzyThreshold)
+	case modeBrowse:
+		browseTracks(q)
+	default:
+		searchTracks(q, search)
 	}
+	applyFilters(q, scope)
+	applyFilters(q, params.Filters)
+	applySort(q, params)
+	params.page(q)
 
-	// Create the query to search for tracks and order by best match
-	// query for non limit or offset
-	query := "SELECT track.TrackId, track.Name, artist.Name, album.Title, " + 
-		"track.AlbumId, track.MediaTypeId, track.GenreId, track.Composer, " + 
-		"track.Milliseconds, track.Bytes, track.UnitPrice " + 
-		"FROM track " + 
-		"INNER JOIN album ON track.AlbumId = album.AlbumId " +
-		"INNER JOIN artist ON album.ArtistId = artist.ArtistId " + 
-		"WHERE track.Name LIKE '%" + string(search) + "%' " +
-		"ORDER BY (CASE WHEN track.Name = '" + string(search) + "' THEN 1 " +
-		"WHEN track.name LIKE '" + string(search) + "%' THEN 2 ELSE 3 END), " + 
-		"track.Name"
-	// query with limit
-	query1 := "SELECT track.TrackId, track.Name, artist.Name, album.Title, " + 
-		"track.AlbumId, track.MediaTypeId, track.GenreId, track.Composer, " + 
-		"track.Milliseconds, track.Bytes, track.UnitPrice " + 
-		"FROM track " + 
-		"INNER JOIN album ON track.AlbumId = album.AlbumId " +
-		"INNER JOIN artist ON album.ArtistId = artist.ArtistId " + 
-		"WHERE track.Name LIKE '%" + string(search) + "%' " +
-		"ORDER BY (CASE WHEN track.Name = '" + string(search) + "' THEN 1 " +
-		"WHEN track.name LIKE '" + string(search) + "%' THEN 2 ELSE 3 END), " + 
-		"track.Name LIMIT " + string(limit)
-	// query with limit and offset
-	query2 := "SELECT track.TrackId, track.Name, artist.Name, album.Title, " + 
-		"track.AlbumId, track.MediaTypeId, track.GenreId, track.Composer, " + 
-		"track.Milliseconds, track.Bytes, track.UnitPrice " + 
-		"FROM track " + 
-		"INNER JOIN album ON track.AlbumId = album.AlbumId " +
-		"INNER JOIN artist ON album.ArtistId = artist.ArtistId " + 
-		"WHERE track.Name LIKE '%" + string(search) + "%' " +
-		"ORDER BY (CASE WHEN track.Name = '" + string(search) + "' THEN 1 " +
-		"WHEN track.name LIKE '" + string(search) + "%' THEN 2 ELSE 3 END), " + 
-		"track.Name LIMIT " + string(limit) + " OFFSET " + string(offset)
-	
-	// Search the database
-	var results *sql.Rows
-	if len(limit) < 1 {
-		results, err = db.Query(query) 
-	} else if len(offset) < 1 {
-		if _, err := strconv.Atoi(limit); err != nil {
-			errorHandler(w, http.StatusBadRequest, "400 Error: Bad request")
-			return
-		} 
-		results, err = db.Query(query1)
-	} else {
-		if _, err := strconv.Atoi(limit); err != nil {
-			errorHandler(w, http.StatusBadRequest, "400 Error: Bad request")
-			return
-		} 
-		if _, err := strconv.Atoi(offset); err != nil {
-			errorHandler(w, http.StatusBadRequest, "400 Error: Bad request")
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.QueryTimeout)
+	defer cancel()
+
+	// Count the matching tracks when the results are paged or wrapped
+	var page pageInfo
+	paged := params.Envelope || q.hasLimit
+	if paged {
+		total, err := s.countRows(ctx, q)
+		if err != nil {
+			errorHandler(w, r, databaseError(err))
 			return
 		}
-		results, err = db.Query(query2)
+		if params.After != nil {
+			page = newCursorPageInfo(total, q)
+		} else {
+			page = newPageInfo(r.URL, total, q)
+		}
 	}
-	if err != nil {
-		errorHandler(w, http.StatusInternalServerError, 
-			"500 Error: Database error")
-		return
+
+	// Skip to the track after the cursor
+	if params.After != nil && params.Mode == modeBrowse {
+		afterTrack(q, *params.After)
+	} else if params.After != nil {
+		afterCursor(q, search, *params.After)
+	}
+
+	// Fetch one track more than the page size to know if another page follows
+	pageSize := 0
+	if q.hasLimit && q.limit > 0 {
+		pageSize = q.limit
+		q.Limit(pageSize + 1)
 	}
 
 	// Create a Track item for each returned track from the search 
 	// and write as an array of JSON objects
 	var track Track
+	var last Track
+	view := viewFields(params)
 	count := 0
-	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, "[")
+	more := false
+	enc := format.encoder()
+	jsonEnc, _ := enc.(*jsonEncoder)
+	if jsonEnc != nil {
+		jsonEnc.pretty = prettyJSON(r)
+	}
+	if params.Envelope {
+		jsonEnc.prefix, jsonEnc.suffix = page.envelope()
+	}
+	// Formats with a header get it from an empty track of the same shape
+	template := &Track{}
+	if params.Mode == modeFuzzy {
+		template.Score = new(float64)
+	}
+	var header interface{} = template
+	if view != nil {
+		header = trackView{template, view}
+	}
+	stream := newEncodedTrackStream(w, r, s.config.StreamBuffer, enc, header)
+	stream.declareTrailer(nextCursorHeader)
+	if params.After != nil {
+		stream.declareTrailer("Link")
+	}
+	// The paging headers are set through the stream, which drops them again
+	// if the response fails before it is sent
+	if paged {
+		page.setHeaders(stream.Header)
+	}
+
+	// Search the database with the prepared statement
+	query, args := q.Build()
+	results, err := s.store.Query(ctx, query, args...)
+	if err != nil {
+		stream.Fail(databaseError(err))
+		return
+	}
+	defer results.Close()
+
 	for results.Next() {
-		if err = results.Scan(&track.TrackId, &track.Name, &track.Artist, 
-			&track.Album, &track.AlbumId, &track.MediaTypeId, &track.GenreId,
-			&track.Composer, &track.Milliseconds, &track.Bytes, 
-			&track.UnitPrice); err != nil {
-				errorHandler(w, http.StatusInternalServerError, 
-					"500 Error: Server error")
-				return
-			}	
-
-		trackJSON, err := json.MarshalIndent(&track, "", "    ")
-		if err != nil {
-			errorHandler(w, http.StatusInternalServerError, 
-				"500 Error: Encoding error")
+		dest := fieldRefs(&track, scan)
+		if params.Mode == modeFuzzy {
+			dest = append(dest, &track.Score)
+		}
+		if err = results.Scan(dest...); err != nil {
+			stream.Fail(errServer)
 			return
 		}
-		// On first iteration, omit comma for array
-		if count == 0 {
-			fmt.Fprintf(w, "%s", trackJSON)
-		} else {
-			fmt.Fprintf(w, ",\n%s", trackJSON)
+		// The extra track is only used to detect the next page
+		if pageSize > 0 && count == pageSize {
+			more = true
+			break
+		}
+		var item interface{} = &track
+		if view != nil {
+			item = trackView{&track, view}
 		}
-		count = count + 1
+		if err = stream.Write(item); err != nil {
+			stream.Fail(errEncoding)
+			return
+		}
+		last = track
+		count++
+	}
+	// Check for errors that ended the iteration early
+	if err = results.Err(); err != nil {
+		stream.Fail(databaseError(err))
+		return
 	}
 
-	fmt.Fprintf(w, "]")
-	results.Close() 
-	db.Close()
-	log.Println("Search query completed for: " + string(search))
+	// Give the cursor for the next page once the last track is known
+	// Cursors follow the substring ranking or TrackId order, so are not
+	// given in other modes or for other sort orders
+	if more && (params.Mode == modeSubstring || params.Mode == modeBrowse) &&
+		len(params.Sort) == 0 {
+		token := encodeCursor(s.cursorSecret, cursorAfter(search, &last))
+		stream.Header(nextCursorHeader, token)
+		page.setNextCursor(r.URL, token, params.After != nil)
+		// Cursor pages only have a next link, known now the page is read
+		if params.After != nil {
+			stream.Header("Link", page.links())
+		}
+		if params.Envelope {
+			_, jsonEnc.suffix = page.envelope()
+		}
+	}
+	stream.Close()
+
+	logAt(levelInfo, completed)
 	return
 }
 
+// Function to count the rows matched by the WHERE clause of q
+func (s *Server) countRows(ctx context.Context, q *queryBuilder) (int, error) {
+	query, args := q.BuildCount()
+	var total int
+	err := s.store.QueryRow(ctx, query, args, &total)
+	return total, err
+}
+
 // Function used to pass favicon
-func faviconHandler(w http.ResponseWriter, r *http.Request) {
-	http.ServeFile(w, r, "./note.ico")
+func (s *Server) faviconHandler(w http.ResponseWriter, r *http.Request) {
+	http.ServeFile(w, r, s.config.FaviconPath)
 }
 
-// Driver function
-func main() {
+// Function to register the request handlers on a new router
+func (s *Server) routes() http.Handler {
+	rt := &router{}
+
 	// Pass favicon
-	http.HandleFunc("/favicon.ico", faviconHandler)
+	rt.HandleFunc("/favicon.ico", s.faviconHandler)
 
 	// Function to handle incoming requests
-	http.HandleFunc("/", handler)
+	rt.HandleFunc("/", s.handler)
+	rt.HandleFunc("/tracks", s.tracksHandler)
+	rt.HandleFunc("/tracks/{id}", s.trackHandler)
+	rt.HandleFunc("/albums", s.albumsHandler)
+	rt.HandleFunc("/albums/{id}", s.albumHandler)
+	rt.HandleFunc("/albums/{id}/tracks", s.albumTracksHandler)
+	rt.HandleFunc("/artists", s.artistsHandler)
+	rt.HandleFunc("/artists/{id}", s.artistHandler)
+	rt.HandleFunc("/artists/{id}/albums", s.artistAlbumsHandler)
+	rt.HandleFunc("/genres", s.genresHandler)
+	rt.HandleFunc("/playlists", s.playlistsHandler)
+	rt.HandleFunc("/playlists/{id}", s.playlistHandler)
+	rt.HandleFunc("/playlists/{id}/tracks", s.playlistTracksHandler)
+	rt.HandleFunc("/playlists/{id}/tracks/{trackId}", s.playlistTrackHandler)
+	for path, report := range salesReports {
+		rt.HandleFunc(path, s.salesReportHandler(report))
+	}
+	rt.HandleFunc("/reports/revenue", s.revenueHandler)
+	rt.HandleFunc("/customers", s.customersHandler)
+	rt.HandleFunc("/customers/{id}", s.customerHandler)
+	rt.HandleFunc("/customers/{id}/invoices", s.customerInvoicesHandler)
+	rt.HandleFunc("/employees", s.employeesHandler)
+	rt.HandleFunc("/employees/{id}", s.employeeHandler)
+	rt.HandleFunc("/employees/{id}/reports", s.employeeReportsHandler)
+	rt.HandleFunc("/media-types", s.mediaTypesHandler)
+
+	return withRequestID(withPretty(s.withCaching(rt)))
+}
 
-	// Listen for request on port 4041
-	log.Fatal(http.ListenAndServe(":4041", nil))
-}
\ No newline at end of file
+// Driver function
+func main() {
+	// Set log ouput to Stdout
+	log.SetOutput(os.Stdout)
+
+	// Read the settings from flags, environment and config file
+	config, err := LoadConfig(os.Args[1:], os.Getenv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	minLogLevel, _ = parseLogLevel(config.LogLevel)
+
+	// Open the database once and prepare the search statements
+	store, err := OpenStore(config.storeOptions())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Prepare(searchQueries()...); err != nil {
+		log.Fatal(err)
+	}
+
+	// Compress responses unless turned off
+	handler := NewServer(store, config).routes()
+	if config.CompressMinSize >= 0 {
+		handler = withCompression(handler, config.CompressMinSize)
+	}
+
+	srv := &http.Server{
+		Addr:         config.ListenAddr,
+		Handler:      handler,
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+		IdleTimeout:  config.IdleTimeout,
+	}
+
+	// Listen for requests on the configured address
+	logAt(levelInfo, "Listening on "+config.ListenAddr)
+	log.Fatal(srv.ListenAndServe())
+}
@@ -1,11 +1,50 @@
 package main
 
 import (
-    "net/http"
-    "net/http/httptest"
-    "testing"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
 )
 
+// Server shared by every test, backed by the Chinook database
+var testServer *Server
+
+// Open the database once for the whole test run
+func TestMain(m *testing.M) {
+	config := defaultConfig()
+	store, err := OpenStore(config.storeOptions())
+	if err != nil {
+		log.Fatal(err)
+	}
+	testServer = NewServer(store, config)
+
+	code := m.Run()
+	store.Close()
+	os.Exit(code)
+}
+
+// Function to make a GET request to a handler with the given headers,
+// leaving out those with empty values
+func getWithHeaders(handler http.Handler, target string,
+	headers map[string]string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for name, value := range headers {
+		if value != "" {
+			req.Header.Set(name, value)
+		}
+	}
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
 // Function to check the http response code for errors 
 func ResponseCodeTest(rec *httptest.ResponseRecorder, req *http.Request,
 	 err error, status int, t *testing.T) {
@@ -13,7 +52,7 @@ func ResponseCodeTest(rec *httptest.ResponseRecorder, req *http.Request,
         t.Fatal(err)
     }
 
-	handler(rec, req)
+	testServer.handler(rec, req)
 
 	// Check the status code is expected code
 	if rec.Code != status {
@@ -24,6 +63,7 @@ func ResponseCodeTest(rec *httptest.ResponseRecorder, req *http.Request,
 }
 
 // Function to check the if content from JSON response matches expected
+// Both are parsed and compared as values, so formatting does not matter
 func ResponseJSONTest(rec *httptest.ResponseRecorder, ctype string,
 	 expected string, t *testing.T) {
 	// Check content type
@@ -34,7 +74,16 @@ func ResponseJSONTest(rec *httptest.ResponseRecorder, ctype string,
 	}
 
 	// Check body of response
-	if rec.Body.String() != expected {
+	var got, want interface{}
+	if err := json.Unmarshal([]byte(expected), &want); err != nil {
+		t.Fatalf("expected body is not valid JSON: %v", err)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Errorf("handler returned invalid JSON: %v\n\n%v", err,
+			rec.Body.String())
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
 		t.Errorf(
 			"handler returned unexpected body. \n\ngot:\n\n%v\n\nwant\n\n%v",
 			rec.Body.String(), expected)
@@ -57,8 +106,6 @@ func TestHandler(t *testing.T) {
 	ctype1 := rec1.Header().Get("Content-Type");
 
 	// expected body to check against response body
-	// Must be four spaces from margin (not tab) to correctly match output
-	// from server.go
     expected1 := `[{
     "TrackId": 1134,
     "Name": "Jesus Of Suburbia / City Of The Damned / I Don't Care /` + 
@@ -90,8 +137,6 @@ func TestHandler(t *testing.T) {
 	ctype2 := rec2.Header().Get("Content-Type")
 
 	// expected body to check against response body
-	// Must be four spaces from margin (not tab) to correctly match output 
-	// from server.go
 	expected2 := `[{
     "TrackId": 2599,
     "Name": "London Calling",
@@ -164,8 +209,6 @@ func TestHandler(t *testing.T) {
 	ctype6 := rec6.Header().Get("Content-Type")
 
 	// expected body to check against response body
-	// Must be four spaces from margin (not tab) to correctly match output 
-	// from server.go
 	expected6 := `[{
     "TrackId": 1955,
     "Name": "Please Don't Touch",
@@ -196,8 +239,6 @@ func TestHandler(t *testing.T) {
 	ctype7 := rec7.Header().Get("Content-Type")
 
 	// expected body to check against response body
-	// Must be four spaces from margin (not tab) to correctly match output 
-	// from server.go
 	expected7 := `[{
     "TrackId": 3070,
     "Name": "Jump",
@@ -293,8 +334,6 @@ func TestHandler(t *testing.T) {
 	ctype8 := rec8.Header().Get("Content-Type")
 
 	// expected body to check against response body
-	// Must be four spaces from margin (not tab) to correctly match output 
-	// from server.go
 	expected8 := `[{
     "TrackId": 3070,
     "Name": "Jump",
@@ -410,8 +449,6 @@ func TestHandler(t *testing.T) {
 	ctype11 := rec11.Header().Get("Content-Type")
 
 	// expected body to check against response body
-	// Must be four spaces from margin (not tab) to correctly match output 
-	// from server.go
 	expected11 := `[{
     "TrackId": 3070,
     "Name": "Jump",
@@ -442,8 +479,6 @@ func TestHandler(t *testing.T) {
 	ctype12 := rec12.Header().Get("Content-Type")
 
 	// expected body to check against response body
-	// Must be four spaces from margin (not tab) to correctly match output 
-	// from server.go
 	expected12 := `[{
     "TrackId": 1832,
     "Name": "Jump In The Fire",
@@ -473,7 +508,8 @@ func TestHandler(t *testing.T) {
 	
 	ResponseJSONTest(rec12, ctype12, expected12, t)
 
-	// Request 13: Ensure correct behaviour when offset without limit
+	// Request 13: Ensure offset without limit skips tracks using the
+	// default page size
 	// Create a ResponseRecorder to record the response.
 	rec13 := httptest.NewRecorder()
 
@@ -487,48 +523,7 @@ func TestHandler(t *testing.T) {
 	ctype13 := rec13.Header().Get("Content-Type")
 
 	// expected body to check against response body
-	// Must be four spaces from margin (not tab) to correctly match output 
-	// from server.go
 	expected13 := `[{
-    "TrackId": 3070,
-    "Name": "Jump",
-    "Artist": "Van Halen",
-    "Album": "The Best Of Van Halen, Vol. I",
-    "AlbumId": 243,
-    "MediaTypeId": 1,
-    "GenreId": 1,
-    "Composer": "Edward Van Halen, Alex Van Halen, David Lee Roth",
-    "Milliseconds": 241711,
-    "Bytes": 7911090,
-    "UnitPrice": 0.99
-},
-{
-    "TrackId": 3300,
-    "Name": "Jump Around",
-    "Artist": "House Of Pain",
-    "Album": "House of Pain",
-    "AlbumId": 258,
-    "MediaTypeId": 1,
-    "GenreId": 17,
-    "Composer": "E. Schrody/L. Muggerud",
-    "Milliseconds": 217835,
-    "Bytes": 8715653,
-    "UnitPrice": 0.99
-},
-{
-    "TrackId": 3317,
-    "Name": "Jump Around (Pete Rock Remix)",
-    "Artist": "House Of Pain",
-    "Album": "House of Pain",
-    "AlbumId": 258,
-    "MediaTypeId": 1,
-    "GenreId": 17,
-    "Composer": "E. Schrody/L. Muggerud",
-    "Milliseconds": 236120,
-    "Bytes": 9447101,
-    "UnitPrice": 0.99
-},
-{
     "TrackId": 1832,
     "Name": "Jump In The Fire",
     "Artist": "Metallica",
@@ -570,4 +565,522 @@ func TestHandler(t *testing.T) {
 	
 	ResponseJSONTest(rec13, ctype13, expected13, t)
 
-}
\ No newline at end of file
+	// Request 14: Ensure LIKE wildcards in the search are matched literally
+	// Create a ResponseRecorder to record the response.
+	rec14 := httptest.NewRecorder()
+
+	// Create a request to pass to our handler.
+	req14, err14 := http.NewRequest(http.MethodGet,
+		"http://localhost:4041/?search=7%25", nil)
+
+	ResponseCodeTest(rec14, req14, err14, http.StatusOK, t)
+
+	// Get content type
+	ctype14 := rec14.Header().Get("Content-Type")
+
+	// expected body to check against response body
+	expected14 := `[{
+    "TrackId": 3166,
+    "Name": ".07%",
+    "Artist": "Heroes",
+    "Album": "Heroes, Season 1",
+    "AlbumId": 228,
+    "MediaTypeId": 3,
+    "GenreId": 21,
+    "Composer": null,
+    "Milliseconds": 2585794,
+    "Bytes": 541715199,
+    "UnitPrice": 1.99
+}]`
+
+	ResponseJSONTest(rec14, ctype14, expected14, t)
+
+}
+// Function to check the error envelope of a failed request
+func ResponseErrorTest(rec *httptest.ResponseRecorder, code string,
+	field string, t *testing.T) {
+	if ctype := rec.Header().Get("Content-Type"); ctype != "application/json" {
+		t.Errorf(
+			"content type header does not match: \n\ngot\n\n%v\n\nwant\n\n%v",
+			ctype, "application/json")
+	}
+
+	var body struct {
+		Error apiError `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error response is not valid JSON: %v\n\n%s", err,
+			rec.Body.String())
+	}
+	if body.Error.Code != code {
+		t.Errorf("wrong error code: \n\ngot\n\n%v\n\nwant\n\n%v",
+			body.Error.Code, code)
+	}
+	if body.Error.Field != field {
+		t.Errorf("wrong error field: \n\ngot\n\n%v\n\nwant\n\n%v",
+			body.Error.Field, field)
+	}
+	if body.Error.Message == "" {
+		t.Errorf("error response has no message")
+	}
+	if body.Error.RequestID != rec.Header().Get("X-Request-ID") {
+		t.Errorf("request_id %q does not match X-Request-ID header %q",
+			body.Error.RequestID, rec.Header().Get("X-Request-ID"))
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	tests := []struct {
+		method string
+		url    string
+		status int
+		code   string
+		field  string
+	}{
+		{http.MethodPost, "/?search=jump", http.StatusMethodNotAllowed,
+			"method_not_allowed", ""},
+		{http.MethodGet, "/", http.StatusBadRequest, "missing_search",
+			"search"},
+		{http.MethodGet, "/?search=", http.StatusBadRequest, "empty_search",
+			"search"},
+		{http.MethodGet, "/?search=jump&limit=a", http.StatusBadRequest,
+			"invalid_limit", "limit"},
+		{http.MethodGet, "/?search=jump&limit=5&offset=a",
+			http.StatusBadRequest, "invalid_offset", "offset"},
+		{http.MethodGet, "/?search=jump&limit=-1", http.StatusBadRequest,
+			"invalid_limit", "limit"},
+		{http.MethodGet, "/?search=jump&limit=0", http.StatusBadRequest,
+			"invalid_limit", "limit"},
+		{http.MethodGet, "/?search=jump&limit=1001", http.StatusBadRequest,
+			"invalid_limit", "limit"},
+		{http.MethodGet, "/?search=jump&offset=-1", http.StatusBadRequest,
+			"invalid_offset", "offset"},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest(test.method,
+			"http://localhost:4041"+test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testServer.routes().ServeHTTP(rec, req)
+
+		if rec.Code != test.status {
+			t.Errorf("%s %s returned wrong status code: \n\ngot\n\n%v"+
+				"\n\nwant\n\n%v", test.method, test.url, rec.Code, test.status)
+		}
+		ResponseErrorTest(rec, test.code, test.field, t)
+	}
+
+	// 405 responses must list the allowed methods
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, "/?search=jump", nil)
+	testServer.routes().ServeHTTP(rec, req)
+	if allow := rec.Header().Get("Allow"); allow != http.MethodGet {
+		t.Errorf("wrong Allow header: \n\ngot\n\n%v\n\nwant\n\n%v",
+			allow, http.MethodGet)
+	}
+}
+
+func TestPaginationEnvelope(t *testing.T) {
+	// Request with a limit and offset inside the matching tracks
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet,
+		"/?search=jump&limit=2&offset=3&envelope=true", nil)
+	testServer.routes().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: \n\ngot\n\n%v"+
+			"\n\nwant\n\n%v", rec.Code, http.StatusOK)
+	}
+
+	var body struct {
+		Data   []map[string]interface{} `json:"data"`
+		Total  int                      `json:"total"`
+		Limit  *int                     `json:"limit"`
+		Offset int                      `json:"offset"`
+		Next   *string                  `json:"next"`
+		Prev   *string                  `json:"prev"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("envelope is not valid JSON: %v\n\n%s", err,
+			rec.Body.String())
+	}
+
+	if len(body.Data) != 2 || body.Data[0]["Name"] != "Jump In The Fire" {
+		t.Errorf("envelope has wrong data: %v", body.Data)
+	}
+	if body.Total != 6 || body.Limit == nil || *body.Limit != 2 ||
+		body.Offset != 3 {
+		t.Errorf("envelope has wrong counts: total %v limit %v offset %v",
+			body.Total, body.Limit, body.Offset)
+	}
+	wantNext := "/?envelope=true&limit=2&offset=5&search=jump"
+	if body.Next == nil || *body.Next != wantNext {
+		t.Errorf("wrong next link: \n\ngot\n\n%v\n\nwant\n\n%v",
+			body.Next, wantNext)
+	}
+	wantPrev := "/?envelope=true&limit=2&offset=1&search=jump"
+	if body.Prev == nil || *body.Prev != wantPrev {
+		t.Errorf("wrong prev link: \n\ngot\n\n%v\n\nwant\n\n%v",
+			body.Prev, wantPrev)
+	}
+
+	// Headers are set for paged results with or without the envelope
+	if total := rec.Header().Get("X-Total-Count"); total != "6" {
+		t.Errorf("wrong X-Total-Count: \n\ngot\n\n%v\n\nwant\n\n%v",
+			total, "6")
+	}
+	wantLink := `</?envelope=true&limit=2&offset=5&search=jump>; rel="next", ` +
+		`</?envelope=true&limit=2&offset=1&search=jump>; rel="prev", ` +
+		`</?envelope=true&limit=2&offset=0&search=jump>; rel="first", ` +
+		`</?envelope=true&limit=2&offset=4&search=jump>; rel="last"`
+	if link := rec.Header().Get("Link"); link != wantLink {
+		t.Errorf("wrong Link header: \n\ngot\n\n%v\n\nwant\n\n%v",
+			link, wantLink)
+	}
+
+	// Last page has no next link
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet,
+		"/?search=jump&limit=5&offset=5&envelope=true", nil)
+	testServer.routes().ServeHTTP(rec, req)
+	body.Next = nil
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Next != nil {
+		t.Errorf("last page has a next link: %v", *body.Next)
+	}
+}
+
+func TestCursorPagination(t *testing.T) {
+	// Follow the next cursor through every page of a search
+	var names []string
+	target := "/?search=jump&limit=2"
+	for page := 0; target != ""; page++ {
+		if page > 5 {
+			t.Fatalf("cursor pagination did not end")
+		}
+		rec := httptest.NewRecorder()
+		testServer.routes().ServeHTTP(rec,
+			httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s returned wrong status code: \n\ngot\n\n%v"+
+				"\n\nwant\n\n%v", target, rec.Code, http.StatusOK)
+		}
+
+		var tracks []map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &tracks); err != nil {
+			t.Fatal(err)
+		}
+		for _, track := range tracks {
+			names = append(names, track["Name"].(string))
+		}
+
+		target = ""
+		if token := rec.Header().Get("X-Next-Cursor"); token != "" {
+			target = "/?search=jump&limit=2&cursor=" + token
+		}
+	}
+
+	want := []string{"Jump", "Jump Around", "Jump Around (Pete Rock Remix)",
+		"Jump In The Fire", "Disc Jockey Jump", "When My Left Eye Jumps"}
+	if strings.Join(names, "|") != strings.Join(want, "|") {
+		t.Errorf("cursor pages returned wrong tracks: \n\ngot\n\n%v"+
+			"\n\nwant\n\n%v", names, want)
+	}
+
+	// Cursors must be rejected if altered, reused for another search or
+	// combined with an offset
+	rec := httptest.NewRecorder()
+	testServer.routes().ServeHTTP(rec,
+		httptest.NewRequest(http.MethodGet, "/?search=jump&limit=2", nil))
+	token := rec.Header().Get("X-Next-Cursor")
+
+	bad := []struct {
+		url   string
+		code  string
+		field string
+	}{
+		{"/?search=jump&limit=2&cursor=x" + token, "invalid_cursor",
+			"cursor"},
+		{"/?search=london&limit=2&cursor=" + token, "invalid_cursor",
+			"cursor"},
+		{"/?search=jump&limit=2&offset=2&cursor=" + token,
+			"cursor_with_offset", "offset"},
+	}
+	for _, test := range bad {
+		rec := httptest.NewRecorder()
+		testServer.routes().ServeHTTP(rec,
+			httptest.NewRequest(http.MethodGet, test.url, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s returned wrong status code: \n\ngot\n\n%v"+
+				"\n\nwant\n\n%v", test.url, rec.Code, http.StatusBadRequest)
+		}
+		ResponseErrorTest(rec, test.code, test.field, t)
+	}
+}
+
+func TestCursorPageLinks(t *testing.T) {
+	rec := getWithHeaders(testServer.routes(), "/?search=jump&limit=2", nil)
+	token := rec.Header().Get("X-Next-Cursor")
+	target := "/?envelope=true&limit=2&search=jump&cursor=" + token
+
+	// A cursor page links to the next page with the next cursor, and its
+	// envelope has no offset
+	rec = getWithHeaders(testServer.routes(), target, nil)
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("envelope is not valid JSON: %v\n\n%s", err,
+			rec.Body.String())
+	}
+	offset, ok := body["offset"]
+	if !ok || offset != nil {
+		t.Errorf("cursor page has wrong offset: \n\ngot\n\n%v\n\nwant\n\n%v",
+			offset, nil)
+	}
+	next := "/?cursor=" + rec.Header().Get("X-Next-Cursor") +
+		"&envelope=true&limit=2&search=jump"
+	wantLink := "<" + next + `>; rel="next"`
+	if link := rec.Header().Get("Link"); link != wantLink {
+		t.Errorf("wrong Link header: \n\ngot\n\n%v\n\nwant\n\n%v",
+			link, wantLink)
+	}
+	if body["next"] != next {
+		t.Errorf("wrong next link: \n\ngot\n\n%v\n\nwant\n\n%v",
+			body["next"], next)
+	}
+
+	// A streamed cursor page sends the link as a trailer
+	config := testServer.config
+	config.StreamBuffer = 0
+	server := NewServer(testServer.store, config)
+	server.cursorSecret = testServer.cursorSecret
+	rec = getWithHeaders(server.routes(), target, nil)
+	if link := rec.Result().Trailer.Get("Link"); link != wantLink {
+		t.Errorf("wrong Link trailer: \n\ngot\n\n%v\n\nwant\n\n%v",
+			link, wantLink)
+	}
+}
+
+func TestDefaultPageSize(t *testing.T) {
+	// A search matching more tracks than the default page size is cut short
+	rec := httptest.NewRecorder()
+	testServer.routes().ServeHTTP(rec,
+		httptest.NewRequest(http.MethodGet, "/?search=a", nil))
+
+	var tracks []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &tracks); err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != testServer.config.DefaultPageSize {
+		t.Errorf("wrong number of tracks: \n\ngot\n\n%v\n\nwant\n\n%v",
+			len(tracks), testServer.config.DefaultPageSize)
+	}
+	if rec.Header().Get("X-Next-Cursor") == "" {
+		t.Errorf("first page of a large search has no next cursor")
+	}
+}
+
+func TestBrowseTracks(t *testing.T) {
+	// Without a search every track is listed in TrackId order
+	rec := httptest.NewRecorder()
+	testServer.routes().ServeHTTP(rec,
+		httptest.NewRequest(http.MethodGet, "/tracks?limit=3", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("wrong status code: \n\ngot\n\n%v\n\nwant\n\n%v",
+			rec.Code, http.StatusOK)
+	}
+	if total := rec.Header().Get("X-Total-Count"); total != "3503" {
+		t.Errorf("wrong total: \n\ngot\n\n%v\n\nwant\n\n%v", total, "3503")
+	}
+
+	// Follow the next cursor through every page of a filtered listing
+	var ids []float64
+	target := "/tracks?album_id=1&limit=4"
+	for page := 0; target != ""; page++ {
+		if page > 5 {
+			t.Fatalf("cursor pagination did not end")
+		}
+		rec := httptest.NewRecorder()
+		testServer.routes().ServeHTTP(rec,
+			httptest.NewRequest(http.MethodGet, target, nil))
+		var tracks []map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &tracks); err != nil {
+			t.Fatal(err)
+		}
+		for _, track := range tracks {
+			ids = append(ids, track["TrackId"].(float64))
+		}
+
+		target = ""
+		if token := rec.Header().Get("X-Next-Cursor"); token != "" {
+			target = "/tracks?album_id=1&limit=4&cursor=" + token
+		}
+	}
+	want := []float64{1, 6, 7, 8, 9, 10, 11, 12, 13, 14}
+	if fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Errorf("cursor pages returned wrong tracks: \n\ngot\n\n%v"+
+			"\n\nwant\n\n%v", ids, want)
+	}
+
+	// A search on /tracks is ranked as on /
+	names := searchNames(t, testServer, "/tracks?search=jump&limit=1")
+	if len(names) != 1 || names[0] != "Jump" {
+		t.Errorf("search returned wrong tracks: %v", names)
+	}
+
+	// Search modes cannot be used without a search, which / still requires
+	bad := []struct {
+		url   string
+		code  string
+		field string
+	}{
+		{"/tracks?mode=fulltext", "missing_search", "search"},
+		{"/tracks?fuzzy=true", "missing_search", "search"},
+		{"/tracks?search_fields=name", "invalid_search_fields",
+			"search_fields"},
+		{"/?limit=3", "missing_search", "search"},
+	}
+	for _, test := range bad {
+		rec := httptest.NewRecorder()
+		testServer.routes().ServeHTTP(rec,
+			httptest.NewRequest(http.MethodGet, test.url, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s returned wrong status code: \n\ngot\n\n%v"+
+				"\n\nwant\n\n%v", test.url, rec.Code, http.StatusBadRequest)
+		}
+		ResponseErrorTest(rec, test.code, test.field, t)
+	}
+}
+
+func TestMarshalTrackValue(t *testing.T) {
+	track := Track{}
+	track.TrackId.Int64, track.TrackId.Valid = 7, true
+	track.Name.String, track.Name.Valid = "Jump", true
+	track.UnitPrice.Float64, track.UnitPrice.Valid = 0.99, true
+
+	// A value and a pointer must encode the same way
+	for _, v := range []interface{}{track, &track} {
+		body, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := `{"TrackId":7,"Name":"Jump","Artist":null,"Album":null,` +
+			`"AlbumId":null,"MediaTypeId":null,"GenreId":null,` +
+			`"Composer":null,"Milliseconds":null,"Bytes":null,` +
+			`"UnitPrice":0.99}`
+		if string(body) != want {
+			t.Errorf("wrong encoding of %T: \n\ngot\n\n%v\n\nwant\n\n%v", v,
+				string(body), want)
+		}
+
+		var decoded Track
+		if err := json.Unmarshal(body, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded != track {
+			t.Errorf("round trip changed the track: \n\ngot\n\n%+v\n\nwant"+
+				"\n\n%+v", decoded, track)
+		}
+	}
+}
+
+func TestNullText(t *testing.T) {
+	var ni NullInt64
+	if err := ni.UnmarshalText([]byte("42")); err != nil || !ni.Valid ||
+		ni.Int64 != 42 {
+		t.Errorf("wrong NullInt64 from text: %+v %v", ni, err)
+	}
+	if text, _ := ni.MarshalText(); string(text) != "42" {
+		t.Errorf("wrong text for NullInt64: %q", text)
+	}
+	if err := ni.UnmarshalText([]byte("4.2")); err == nil {
+		t.Errorf("expected error for a float in a NullInt64")
+	}
+	if err := ni.UnmarshalText(nil); err != nil || ni.Valid {
+		t.Errorf("empty text did not clear NullInt64: %+v %v", ni, err)
+	}
+
+	var nf NullFloat64
+	if err := nf.UnmarshalText([]byte("0.99")); err != nil || !nf.Valid ||
+		nf.Float64 != 0.99 {
+		t.Errorf("wrong NullFloat64 from text: %+v %v", nf, err)
+	}
+	if text, _ := nf.MarshalText(); string(text) != "0.99" {
+		t.Errorf("wrong text for NullFloat64: %q", text)
+	}
+
+	var ns NullString
+	ns.UnmarshalText([]byte("AC/DC"))
+	if text, _ := ns.MarshalText(); !ns.Valid || string(text) != "AC/DC" {
+		t.Errorf("wrong text for NullString: %+v %q", ns, text)
+	}
+	ns.UnmarshalText([]byte(""))
+	if text, _ := ns.MarshalText(); ns.Valid || len(text) != 0 {
+		t.Errorf("empty text did not clear NullString: %+v %q", ns, text)
+	}
+}
+
+func TestNullValue(t *testing.T) {
+	var ns NullString
+	if err := ns.Scan("Jump"); err != nil || !ns.Valid || ns.String != "Jump" {
+		t.Errorf("wrong NullString from scan: %+v %v", ns, err)
+	}
+	if value, err := ns.Value(); err != nil || value != "Jump" {
+		t.Errorf("wrong value for NullString: %v %v", value, err)
+	}
+	var ni NullInt64
+	if err := ni.Scan(nil); err != nil || ni.Valid {
+		t.Errorf("wrong NullInt64 from scan: %+v %v", ni, err)
+	}
+	if value, err := ni.Value(); err != nil || value != nil {
+		t.Errorf("wrong value for NullInt64: %v %v", value, err)
+	}
+	var nf NullFloat64
+	if err := nf.Scan(1.5); err != nil || nf.Float64 != 1.5 {
+		t.Errorf("wrong NullFloat64 from scan: %+v %v", nf, err)
+	}
+	if value, err := nf.Value(); err != nil || value != 1.5 {
+		t.Errorf("wrong value for NullFloat64: %v %v", value, err)
+	}
+}
+
+func TestPrettyJSON(t *testing.T) {
+	tests := []struct {
+		url    string
+		pretty bool
+	}{
+		{"/?search=jump&limit=2", false},
+		{"/?search=jump&limit=2&pretty=true", true},
+		{"/?search=jump&limit=2&envelope=true&pretty=false", false},
+		{"/albums/4", false},
+		{"/albums/4?pretty=1", true},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		testServer.routes().ServeHTTP(rec,
+			httptest.NewRequest(http.MethodGet, test.url, nil))
+		body := rec.Body.Bytes()
+		if !json.Valid(body) {
+			t.Errorf("%s returned invalid JSON: %s", test.url, body)
+		}
+		var compact bytes.Buffer
+		json.Compact(&compact, body)
+		if pretty := compact.Len() != len(body); pretty != test.pretty {
+			t.Errorf("%s returned wrong formatting: \n\ngot\n\n%s\n\nwant"+
+				"\n\npretty %v", test.url, body, test.pretty)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	testServer.routes().ServeHTTP(rec,
+		httptest.NewRequest(http.MethodGet, "/genres?pretty=yes", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("wrong status code: \n\ngot\n\n%v\n\nwant\n\n%v", rec.Code,
+			http.StatusBadRequest)
+	}
+	ResponseErrorTest(rec, "invalid_pretty", "pretty", t)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+)
+
+// sortKey is one field of a client-selected sort order
+type sortKey struct {
+	field string
+	desc  bool
+}
+
+// Columns each sortable field orders by
+// relevance is not listed as its expression depends on the search mode,
+// see relevanceOrder
+var sortColumns = map[string]string{
+	"track_id": "track.TrackId",
+	"name":     "track.Name",
+	"artist":   "artist.Name",
+	"album":    "album.Title",
+	"composer": "track.Composer",
+	"duration": "track.Milliseconds",
+	"bytes":    "track.Bytes",
+	"price":    "track.UnitPrice",
+}
+
+// Field sorting tracks with the best match first
+const sortRelevance = "relevance"
+
+// Function to list the fields that can be given to sort=, for messages
+func sortFieldNames() string {
+	return "track_id, name, artist, album, composer, duration, bytes, price " +
+		"or relevance"
+}
+
+// Function to parse a sort= value such as "artist,-duration"
+// Fields are separated by commas and sort in ascending order unless
+// prefixed with -. A + prefix is accepted for ascending order
+func parseSort(value string, mode string) ([]sortKey, *apiError) {
+	var keys []sortKey
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(value, ",") {
+		// A + in a URL decodes to a space
+		field = strings.TrimSpace(field)
+		key := sortKey{field: strings.TrimPrefix(field, "+")}
+		if strings.HasPrefix(field, "-") {
+			key = sortKey{field: field[1:], desc: true}
+		}
+		key.field = strings.ToLower(key.field)
+
+		if _, ok := sortColumns[key.field]; !ok && key.field != sortRelevance {
+			e := errInvalidSort.withMessage(
+				"Unknown sort field %q, must be one of %s", field,
+				sortFieldNames())
+			return nil, &e
+		}
+		if key.field == sortRelevance && mode == modeBrowse {
+			e := errInvalidSort.withMessage(
+				"Sorting by relevance needs a search")
+			return nil, &e
+		}
+		if seen[key.field] {
+			e := errInvalidSort.withMessage(
+				"Sort field %q is given more than once", key.field)
+			return nil, &e
+		}
+		seen[key.field] = true
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Function to get the expression and arguments ordering a search by
+// relevance, best match first
+func relevanceOrder(p searchParams) (string, []interface{}, bool) {
+	switch p.Mode {
+	case modeFullText:
+		return ftsRank, nil, false
+	case modeFuzzy:
+		return fuzzyScoreColumn, nil, true
+	default:
+		return rankExpr, rankArgs(p.Search), false
+	}
+}
+
+// Function to replace the order of a query with the client-selected sort
+// TrackId is added last, if not already sorted on, so the order is
+// deterministic
+func applySort(q *queryBuilder, p searchParams) *queryBuilder {
+	if len(p.Sort) == 0 {
+		return q
+	}
+	q.ClearOrder()
+	byTrackId := false
+	for _, key := range p.Sort {
+		column, args, desc := sortColumns[key.field], []interface{}(nil), false
+		if key.field == sortRelevance {
+			column, args, desc = relevanceOrder(p)
+		}
+		if key.desc {
+			desc = !desc
+		}
+		if desc {
+			column += " DESC"
+		}
+		q.OrderBy(column, args...)
+		byTrackId = byTrackId || key.field == "track_id"
+	}
+	if !byTrackId {
+		q.OrderBy("track.TrackId")
+	}
+	return q
+}
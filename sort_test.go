@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseSort(t *testing.T) {
+	keys, err := parseSort("artist, -Duration,+name", modeSubstring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []sortKey{{"artist", false}, {"duration", true}, {"name", false}}
+	if fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Errorf("wrong sort keys: \n\ngot\n\n%v\n\nwant\n\n%v", keys, want)
+	}
+
+	bad := []struct {
+		value string
+		mode  string
+	}{
+		{"genre", modeSubstring},
+		{"name;DROP TABLE track", modeSubstring},
+		{"track.Name", modeSubstring},
+		{"name,", modeSubstring},
+		{"name,-name", modeSubstring},
+		{"relevance", modeBrowse},
+	}
+	for _, test := range bad {
+		if _, err := parseSort(test.value, test.mode); err == nil {
+			t.Errorf("parseSort(%q, %q): expected error, got nil",
+				test.value, test.mode)
+		}
+	}
+}
+
+// Function to get the TrackId of each track returned for a URL
+func trackIds(t *testing.T, target string) []float64 {
+	rec := httptest.NewRecorder()
+	testServer.routes().ServeHTTP(rec,
+		httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s returned wrong status code: \n\ngot\n\n%v\n\nwant\n\n%v"+
+			"\n\n%s", target, rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var tracks []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &tracks); err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]float64, len(tracks))
+	for i, track := range tracks {
+		ids[i], _ = track["TrackId"].(float64)
+	}
+	return ids
+}
+
+func TestSort(t *testing.T) {
+	tests := []struct {
+		url  string
+		want []float64
+	}{
+		{"/tracks?sort=-duration&limit=3", []float64{2820, 3224, 3244}},
+		{"/tracks?genre_id=2&sort=artist,-price,name&limit=3",
+			[]float64{3357, 3349, 3350}},
+		{"/?search=love&sort=relevance,-track_id&limit=3",
+			[]float64{3460, 3355, 3135}},
+		{"/tracks?sort=track_id&limit=3", []float64{1, 2, 3}},
+		{"/tracks?sort=-track_id&limit=3", []float64{3503, 3502, 3501}},
+	}
+
+	for _, test := range tests {
+		ids := trackIds(t, test.url)
+		if fmt.Sprint(ids) != fmt.Sprint(test.want) {
+			t.Errorf("%s returned wrong tracks: \n\ngot\n\n%v\n\nwant\n\n%v",
+				test.url, ids, test.want)
+		}
+	}
+
+	// No cursor is given for a custom sort order, and cursors are refused
+	rec := httptest.NewRecorder()
+	testServer.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+		"/tracks?sort=name&limit=3", nil))
+	if token := rec.Header().Get("X-Next-Cursor"); token != "" {
+		t.Errorf("sorted listing returned a next cursor: %v", token)
+	}
+	rec = httptest.NewRecorder()
+	testServer.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+		"/tracks?sort=foo", nil))
+	ResponseErrorTest(rec, "invalid_sort", "sort", t)
+	rec = httptest.NewRecorder()
+	testServer.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+		"/tracks?sort=name&cursor=abc", nil))
+	ResponseErrorTest(rec, "cursor_with_sort", "sort", t)
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// StoreOptions holds the settings used when opening the database
+type StoreOptions struct {
+	Path         string
+	MaxOpenConns int
+	BusyTimeout  time.Duration
+	ReadOnly     bool
+}
+
+// Most statements cached besides those prepared ahead of time
+// Sort orders, fields, expansions and filters give track queries a great
+// many shapes, so the least recently used are closed beyond this
+const maxCachedStmts = 256
+
+// cachedStmt is a prepared statement in the store's cache
+// Statements prepared ahead of time are pinned and never evicted. An
+// evicted statement is closed once no query is being started with it
+type cachedStmt struct {
+	stmt    *sql.Stmt
+	elem    *list.Element
+	users   int
+	evicted bool
+}
+
+// Store is the server-scoped database handle shared by every request
+// Prepared statements are cached by their SQL text so each distinct query
+// shape is only prepared while it is in use
+// The full-text index is nil when FTS5 is not compiled in, see fts.go
+type Store struct {
+	// Updated atomically, so kept first to be 64-bit aligned
+	writes  int64
+	written int64
+
+	path  string
+	db    *sql.DB
+	index *sql.DB
+	mu    sync.Mutex
+	stmts map[string]*cachedStmt
+	// SQL text of the unpinned statements, most recently used first
+	recent *list.List
+}
+
+// connector opens connections to a data source name with a given driver,
+// so each store can have its own connect hook
+type connector struct {
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+}
+
+// Connect implements driver.Connector
+func (c connector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+// Driver implements driver.Connector
+func (c connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Function to build the sqlite3 data source name for the given options
+func storeDSN(opts StoreOptions) string {
+	params := url.Values{}
+	params.Set("_busy_timeout", fmt.Sprint(opts.BusyTimeout.Milliseconds()))
+	params.Set("_foreign_keys", "1")
+	if opts.ReadOnly {
+		params.Set("mode", "ro")
+	} else {
+		// Take the write lock when a transaction begins, so transactions
+		// that read before writing wait for each other instead of failing
+		params.Set("_txlock", "immediate")
+	}
+	return "file:" + opts.Path + "?" + params.Encode()
+}
+
+// OpenStore opens the database once and checks that it can be reached
+// The full-text index is built at the same time when FTS5 is available
+func OpenStore(opts StoreOptions) (*Store, error) {
+	index, indexURI, err := openSearchIndex(opts.Path)
+	if err != nil && strings.Contains(err.Error(), "no such module: fts5") {
+		logAt(levelWarn, "Full-text search unavailable, "+
+			"build with -tags sqlite_fts5 to enable it")
+	} else if err != nil {
+		return nil, fmt.Errorf("opening database %s: %w", opts.Path, err)
+	}
+
+	drv := &sqlite3.SQLiteDriver{
+		ConnectHook: connectHook(indexURI, opts.ReadOnly),
+	}
+	db := sql.OpenDB(connector{dsn: storeDSN(opts), driver: drv})
+	if opts.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(opts.MaxOpenConns)
+		db.SetMaxIdleConns(opts.MaxOpenConns)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		if index != nil {
+			index.Close()
+		}
+		return nil, fmt.Errorf("opening database %s: %w", opts.Path, err)
+	}
+	return &Store{path: opts.Path, db: db, index: index,
+		stmts: make(map[string]*cachedStmt), recent: list.New()}, nil
+}
+
+// Function to create the hook run on every new connection to the database
+// It registers the SQL functions used by searches and attaches the
+// full-text index at indexURI, if there is one
+func connectHook(indexURI string, readOnly bool) func(*sqlite3.SQLiteConn) error {
+	return func(conn *sqlite3.SQLiteConn) error {
+		if err := conn.RegisterFunc(fuzzyScoreFunc, fuzzyScoreSQL, true); err != nil {
+			return fmt.Errorf("registering %s: %w", fuzzyScoreFunc, err)
+		}
+		if indexURI == "" {
+			return nil
+		}
+		return attachSearchIndex(conn, indexURI, readOnly)
+	}
+}
+
+// storeVersion identifies the state of the database, so responses built
+// from it can be cached until it changes
+type storeVersion struct {
+	Writes   int64
+	Modified time.Time
+}
+
+// Changed records a write committed through the store
+func (s *Store) Changed() {
+	atomic.StoreInt64(&s.written, time.Now().UnixNano())
+	atomic.AddInt64(&s.writes, 1)
+}
+
+// Version gets the state of the database, which changes with every write
+// committed through the store and whenever the database file is modified
+// Writes by other processes are only seen through the file's modification
+// time, including its write-ahead log if there is one
+func (s *Store) Version() storeVersion {
+	v := storeVersion{Writes: atomic.LoadInt64(&s.writes)}
+	if written := atomic.LoadInt64(&s.written); written != 0 {
+		v.Modified = time.Unix(0, written)
+	}
+	for _, path := range []string{s.path, s.path + "-wal"} {
+		info, err := os.Stat(path)
+		if err == nil && info.ModTime().After(v.Modified) {
+			v.Modified = info.ModTime()
+		}
+	}
+	return v
+}
+
+// FullText reports whether the full-text index is available
+func (s *Store) FullText() bool {
+	return s.index != nil
+}
+
+// Function to get the cached statement for query, preparing it on first
+// use and evicting the least recently used statements beyond the limit
+// The statement is prepared without holding s.mu so other queries do not
+// wait for it. If another query prepared it meanwhile, that one is kept
+// A pinned statement is never evicted, otherwise the statement is taken
+// for the start of a query so it is not closed meanwhile, see release
+func (s *Store) cached(query string, pin bool) (*cachedStmt, error) {
+	s.mu.Lock()
+	if c, ok := s.stmts[query]; ok {
+		s.use(c, pin)
+		s.mu.Unlock()
+		return c, nil
+	}
+	s.mu.Unlock()
+
+	stmt, err := s.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.stmts[query]; ok {
+		stmt.Close()
+		s.use(c, pin)
+		return c, nil
+	}
+	c := &cachedStmt{stmt: stmt}
+	c.elem = s.recent.PushFront(query)
+	s.stmts[query] = c
+	s.use(c, pin)
+
+	for s.recent.Len() > maxCachedStmts {
+		oldest := s.recent.Remove(s.recent.Back()).(string)
+		old := s.stmts[oldest]
+		delete(s.stmts, oldest)
+		old.evicted = true
+		if old.users == 0 {
+			old.stmt.Close()
+		}
+	}
+	return c, nil
+}
+
+// Function to pin a cached statement, or mark it most recently used and
+// take it for the start of a query
+// The caller must hold s.mu
+func (s *Store) use(c *cachedStmt, pin bool) {
+	if pin {
+		if c.elem != nil {
+			s.recent.Remove(c.elem)
+			c.elem = nil
+		}
+		return
+	}
+	if c.elem != nil {
+		s.recent.MoveToFront(c.elem)
+	}
+	c.users++
+}
+
+// Function to give back a statement taken by cached, closing it if it
+// was evicted meanwhile
+// Rows already returned keep the statement open until they are closed
+func (s *Store) release(c *cachedStmt) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c.users--
+	if c.evicted && c.users == 0 {
+		c.stmt.Close()
+	}
+}
+
+// Query runs query with its cached prepared statement
+func (s *Store) Query(ctx context.Context, query string,
+	args ...interface{}) (*sql.Rows, error) {
+	c, err := s.cached(query, false)
+	if err != nil {
+		return nil, err
+	}
+	defer s.release(c)
+	return c.stmt.QueryContext(ctx, args...)
+}
+
+// QueryRow runs query with its cached prepared statement and scans the
+// first row into dest, returning sql.ErrNoRows if there is none
+func (s *Store) QueryRow(ctx context.Context, query string, args []interface{},
+	dest ...interface{}) error {
+	c, err := s.cached(query, false)
+	if err != nil {
+		return err
+	}
+	defer s.release(c)
+	return c.stmt.QueryRowContext(ctx, args...).Scan(dest...)
+}
+
+// Prepare adds each query to the statement cache ahead of time, where it
+// stays however rarely it is used
+func (s *Store) Prepare(queries ...string) error {
+	for _, query := range queries {
+		if _, err := s.cached(query, true); err != nil {
+			return fmt.Errorf("preparing %q: %w", query, err)
+		}
+	}
+	return nil
+}
+
+// Close releases every cached statement and the database handle
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for query, c := range s.stmts {
+		c.stmt.Close()
+		delete(s.stmts, query)
+	}
+	s.recent.Init()
+	err := s.db.Close()
+	if s.index != nil {
+		s.index.Close()
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// Ensure prepared statements are cached by their SQL text, and that the
+// cache stops growing at maxCachedStmts
+func TestStoreStmtCache(t *testing.T) {
+	store, err := OpenStore(defaultConfig().storeOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+
+	pinned := searchQueries()[0]
+	if err := store.Prepare(pinned); err != nil {
+		t.Fatal(err)
+	}
+	query := "SELECT 1 UNION ALL SELECT 2"
+	rows, err := store.Query(ctx, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	first := store.stmts[query]
+	if rows, err := store.Query(ctx, query); err != nil {
+		t.Fatal(err)
+	} else {
+		rows.Close()
+	}
+	if store.stmts[query] != first {
+		t.Errorf("statement for %q was prepared twice", query)
+	}
+
+	for i := 0; i < maxCachedStmts+10; i++ {
+		var n int
+		err := store.QueryRow(ctx, fmt.Sprintf("SELECT %d", i), nil, &n)
+		if err != nil || n != i {
+			t.Fatalf("SELECT %d returned %d: %v", i, n, err)
+		}
+	}
+	if len(store.stmts) != maxCachedStmts+1 || store.stmts[pinned] == nil {
+		t.Errorf("cache holds %d statements, want %d with the pinned one",
+			len(store.stmts), maxCachedStmts+1)
+	}
+
+	// Rows of an evicted statement can still be read
+	if store.stmts[query] != nil {
+		t.Errorf("statement for %q was not evicted", query)
+	}
+	count := 0
+	for rows.Next() {
+		count++
+	}
+	if rows.Err() != nil || count != 2 {
+		t.Errorf("evicted statement read %d rows: %v", count, rows.Err())
+	}
+}
+
+// Ensure opening a database file that does not exist fails at startup
+func TestOpenStoreMissingFile(t *testing.T) {
+	store, err := OpenStore(StoreOptions{
+		Path:     t.TempDir() + "/missing.sqlite",
+		ReadOnly: true,
+	})
+	if err == nil {
+		store.Close()
+		t.Errorf("expected error opening missing database, got nil")
+	}
+}
+
+// Ensure queries preparing the same statement at once share one entry
+func TestStoreStmtConcurrent(t *testing.T) {
+	store, err := OpenStore(defaultConfig().storeOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	const query = "SELECT COUNT(*) FROM track"
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			var n int
+			errs <- store.QueryRow(context.Background(), query, nil, &n)
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if len(store.stmts) != 1 || store.stmts[query].users != 0 {
+		t.Errorf("cache holds %d statements after concurrent queries",
+			len(store.stmts))
+	}
+}
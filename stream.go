@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+// Trailer set when a streamed response fails after the status was sent
+const streamErrorTrailer = "X-Stream-Error"
+
+// Header giving the cursor for the next page of results
+const nextCursorHeader = "X-Next-Cursor"
+
+// trackStream writes tracks with a trackEncoder
+// Output is buffered until it grows past bufferSize so that an error in a
+// small result set can still be sent as a normal error response. Once the
+// buffer has been sent the status can no longer change, so a failure is
+// reported by the encoder at the end of the output, if the format allows,
+// and in the X-Stream-Error trailer
+type trackStream struct {
+	w          http.ResponseWriter
+	r          *http.Request
+	bufferSize int
+	enc        trackEncoder
+	trailers   []string
+	headers    []string
+	buf        bytes.Buffer
+	count      int
+	committed  bool
+	err        error
+}
+
+// Function to create a stream writing a bare JSON array to w
+func newTrackStream(w http.ResponseWriter, r *http.Request,
+	bufferSize int) *trackStream {
+	return newEncodedTrackStream(w, r, bufferSize,
+		&jsonEncoder{prefix: "[", suffix: "]"}, nil)
+}
+
+// Function to create a stream writing tracks to w with an encoder
+// template is passed to the encoder's Begin
+func newEncodedTrackStream(w http.ResponseWriter, r *http.Request,
+	bufferSize int, enc trackEncoder, template interface{}) *trackStream {
+	ts := &trackStream{w: w, r: r, bufferSize: bufferSize, enc: enc,
+		trailers: []string{streamErrorTrailer}}
+	ts.err = enc.Begin(&ts.buf, template)
+	return ts
+}
+
+// Write adds a track, or a view of one, to the output
+func (ts *trackStream) Write(track interface{}) error {
+	if ts.err != nil {
+		return ts.err
+	}
+	// Encode separately so a failed track leaves no partial output
+	var item bytes.Buffer
+	if err := ts.enc.Encode(&item, track, ts.count); err != nil {
+		return err
+	}
+	ts.buf.Write(item.Bytes())
+	ts.count++
+
+	if ts.buf.Len() >= ts.bufferSize {
+		ts.flush()
+	}
+	return nil
+}
+
+// Header sets a response header that only belongs to a successful
+// response, sending it as a trailer if streaming has begun
+// Headers set before then are removed again if the stream fails. Trailer
+// names must be declared with declareTrailer before the first Write
+func (ts *trackStream) Header(name string, value string) {
+	if !ts.committed {
+		ts.headers = append(ts.headers, name)
+	}
+	ts.w.Header().Set(name, value)
+}
+
+// Function to allow a header to be sent as a trailer
+func (ts *trackStream) declareTrailer(name string) {
+	ts.trailers = append(ts.trailers, name)
+}
+
+// Function to send the status and any buffered output to the client
+// A response sent before it is complete may still fail, so caches are told
+// not to keep it
+func (ts *trackStream) flush() {
+	if !ts.committed {
+		ts.w.Header().Set("Content-Type", ts.enc.ContentType())
+		ts.w.Header().Set("Cache-Control", "no-store")
+		ts.w.Header().Set("Trailer", strings.Join(ts.trailers, ", "))
+		ts.w.WriteHeader(http.StatusOK)
+		ts.committed = true
+	}
+	ts.w.Write(ts.buf.Bytes())
+	ts.buf.Reset()
+	if f, ok := ts.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close ends the output and sends the rest of the response
+func (ts *trackStream) Close() {
+	if ts.err != nil {
+		ts.Fail(errEncoding)
+		return
+	}
+	if !ts.committed {
+		ts.w.Header().Set("Content-Type", ts.enc.ContentType())
+	}
+	ts.enc.End(&ts.buf)
+	ts.w.Write(ts.buf.Bytes())
+	ts.buf.Reset()
+}
+
+// Fail reports an error that stopped the stream
+// The output stays well formed whether or not the status was already sent
+func (ts *trackStream) Fail(e apiError) {
+	if !ts.committed {
+		ts.buf.Reset()
+		for _, name := range ts.headers {
+			ts.w.Header().Del(name)
+		}
+		errorHandler(ts.w, ts.r, e)
+		return
+	}
+
+	e.RequestID = requestID(ts.r)
+	ts.enc.Fail(&ts.buf, e, ts.count)
+	ts.enc.End(&ts.buf)
+	ts.w.Write(ts.buf.Bytes())
+	ts.buf.Reset()
+	ts.w.Header().Set(streamErrorTrailer, e.Code)
+	logAt(levelError, e.Error()+" after the response had started")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Function to create a track with only an ID and name set
+func testTrack(id int64, name string) *Track {
+	var track Track
+	track.TrackId.Int64, track.TrackId.Valid = id, true
+	track.Name.String, track.Name.Valid = name, true
+	return &track
+}
+
+// Ensure a failure before anything is sent gives a normal error response
+func TestTrackStreamFailBuffered(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/?search=jump", nil)
+
+	stream := newTrackStream(rec, req, 1<<20)
+	newPageInfo(req.URL, 10, newFieldQuery(nil).Limit(1)).setHeaders(
+		stream.Header)
+	if err := stream.Write(testTrack(1, "Jump")); err != nil {
+		t.Fatal(err)
+	}
+	stream.Fail(errServer)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("wrong status code: \n\ngot\n\n%v\n\nwant\n\n%v",
+			rec.Code, http.StatusInternalServerError)
+	}
+	ResponseErrorTest(rec, "server_error", "", t)
+
+	// Paging headers describe the tracks, not the error
+	for _, name := range []string{"X-Total-Count", "Link"} {
+		if got := rec.Header().Get(name); got != "" {
+			t.Errorf("error response has %s header %q", name, got)
+		}
+	}
+}
+
+// Ensure a failure after streaming has begun still gives valid JSON and
+// reports the error in the trailer
+func TestTrackStreamFailStreaming(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/?search=jump", nil)
+
+	// A zero buffer sends every track as soon as it is written
+	stream := newTrackStream(rec, req, 0)
+	for i := int64(1); i <= 3; i++ {
+		if err := stream.Write(testTrack(i, "Jump")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream.Fail(errDatabase)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("wrong status code: \n\ngot\n\n%v\n\nwant\n\n%v",
+			rec.Code, http.StatusOK)
+	}
+
+	var body []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("streamed response is not valid JSON: %v\n\n%s", err,
+			rec.Body.String())
+	}
+	if len(body) != 4 {
+		t.Fatalf("expected 3 tracks and an error object, got %d items",
+			len(body))
+	}
+	if _, ok := body[3]["error"]; !ok {
+		t.Errorf("last item is not an error object: %v", body[3])
+	}
+
+	trailer := rec.Result().Trailer.Get(streamErrorTrailer)
+	if trailer != "database_error" {
+		t.Errorf("wrong %s trailer: \n\ngot\n\n%v\n\nwant\n\n%v",
+			streamErrorTrailer, trailer, "database_error")
+	}
+}
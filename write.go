@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Largest request body accepted by the write endpoints
+const maxBodySize = 1 << 20
+
+// Fields of a track that can be written, the Track fields read from the
+// track table other than TrackId
+var writableFields = func() []trackField {
+	var fields []trackField
+	for _, f := range trackFields {
+		if strings.HasPrefix(f.column, "track.") && f.name != "TrackId" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}()
+
+// Rows a track refers to, which must exist
+var trackReferences = []struct {
+	field string
+	query string
+	id    func(t *Track) NullInt64
+}{
+	{"AlbumId", "SELECT 1 FROM album WHERE AlbumId = ?",
+		func(t *Track) NullInt64 { return t.AlbumId }},
+	{"MediaTypeId", "SELECT 1 FROM mediatype WHERE MediaTypeId = ?",
+		func(t *Track) NullInt64 { return t.MediaTypeId }},
+	{"GenreId", "SELECT 1 FROM genre WHERE GenreId = ?",
+		func(t *Track) NullInt64 { return t.GenreId }},
+}
+
+// Function to get the track table column of a field
+func fieldColumn(f trackField) string {
+	return strings.TrimPrefix(f.column, "track.")
+}
+
+// Function to read a JSON object of track fields from a request body
+// TrackId is accepted when allowID is set. Read-only fields such as Artist
+// and Album are ignored, so a track read with GET can be sent back, but
+// unknown fields are refused
+func readTrackBody(r *http.Request,
+	allowID bool) (map[string]json.RawMessage, *apiError) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		e := errInvalidBody.withMessage("The request body could not be read")
+		return nil, &e
+	}
+	if len(body) > maxBodySize {
+		e := errInvalidBody.withMessage(
+			"The request body is larger than %d bytes", maxBodySize)
+		return nil, &e
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil || raw == nil {
+		e := errInvalidBody.withMessage("The request body must be a JSON object")
+		return nil, &e
+	}
+
+	// Check every name before reading any, in a stable order
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "TrackId" && allowID || containsField(writableFields, name) {
+			continue
+		}
+		if name != "TrackId" && containsField(trackFields, name) {
+			delete(raw, name)
+			continue
+		}
+		e := invalidTrack(name, "Unknown field %q", name)
+		if name == "TrackId" {
+			e = invalidTrack(name, "%s cannot be written", name)
+		}
+		return nil, &e
+	}
+	return raw, nil
+}
+
+// Function to set the fields read by readTrackBody on a track
+// Only the fields present are changed, so a PATCH can be applied to the
+// stored track
+func applyTrackBody(raw map[string]json.RawMessage, track *Track) *apiError {
+	fields := append([]trackField{trackFields[0]}, writableFields...)
+	for _, f := range fields {
+		value, ok := raw[f.name]
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(value, f.ref(track)); err != nil {
+			e := invalidTrack(f.name, "%s has the wrong type", f.name)
+			return &e
+		}
+	}
+	return nil
+}
+
+// Function to create the error for an invalid track field
+func invalidTrack(field string, format string, a ...interface{}) apiError {
+	e := errInvalidTrack.withMessage(format, a...)
+	e.Field = field
+	return e
+}
+
+// Function to check a track against the constraints of the track table
+func validateTrack(track *Track) *apiError {
+	var e apiError
+	switch {
+	case track.TrackId.Valid && track.TrackId.Int64 < 1:
+		e = invalidTrack("TrackId", "TrackId must be a positive integer")
+	case !track.Name.Valid || strings.TrimSpace(track.Name.String) == "":
+		e = invalidTrack("Name", "Name is required")
+	case utf8.RuneCountInString(track.Name.String) > 200:
+		e = invalidTrack("Name", "Name must be at most 200 characters")
+	case !track.AlbumId.Valid:
+		e = invalidTrack("AlbumId", "AlbumId is required")
+	case !track.MediaTypeId.Valid:
+		e = invalidTrack("MediaTypeId", "MediaTypeId is required")
+	case utf8.RuneCountInString(track.Composer.String) > 220:
+		e = invalidTrack("Composer", "Composer must be at most 220 characters")
+	case !track.Milliseconds.Valid || track.Milliseconds.Int64 < 0:
+		e = invalidTrack("Milliseconds",
+			"Milliseconds is required and must not be negative")
+	case track.Bytes.Valid && track.Bytes.Int64 < 0:
+		e = invalidTrack("Bytes", "Bytes must not be negative")
+	case !track.UnitPrice.Valid || track.UnitPrice.Float64 < 0:
+		e = invalidTrack("UnitPrice",
+			"UnitPrice is required and must not be negative")
+	default:
+		return nil
+	}
+	return &e
+}
+
+// Function to check that every row a track refers to exists
+func checkReferences(ctx context.Context, tx *sql.Tx, track *Track) (*apiError,
+	error) {
+	for _, ref := range trackReferences {
+		id := ref.id(track)
+		if !id.Valid {
+			continue
+		}
+		var found int
+		err := tx.QueryRowContext(ctx, ref.query, id.Int64).Scan(&found)
+		if err == sql.ErrNoRows {
+			e := errInvalidTrack.withMessage("%s %d does not exist",
+				ref.field, id.Int64)
+			e.Field = ref.field
+			return &e, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// Function to read a track by id within a transaction
+func readTrack(ctx context.Context, tx *sql.Tx, id int64) (*Track, error) {
+	query, args := newTrackQuery().Where("track.TrackId = ?", id).Build()
+	var track Track
+	err := tx.QueryRowContext(ctx, query, args...).Scan(
+		fieldRefs(&track, scanFields(nil))...)
+	if err != nil {
+		return nil, err
+	}
+	return &track, nil
+}
+
+// Function to refuse writes to a read-only database, writing an error
+// response and returning false
+func (s *Server) requireWritable(w http.ResponseWriter, r *http.Request) bool {
+	if !s.config.ReadOnly {
+		return true
+	}
+	w.Header().Set("Allow", http.MethodGet)
+	errorHandler(w, r, errReadOnly)
+	return false
+}
+
+// Function to run fn in a transaction, committing if it succeeds
+// fn returns the error response for a rejected write, or an error if the
+// database failed, and either one rolls the transaction back
+// A commit changes the store's version, so cached responses are replaced
+func (s *Server) inTransaction(w http.ResponseWriter, r *http.Request,
+	fn func(ctx context.Context, tx *sql.Tx) (*apiError, error)) bool {
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.QueryTimeout)
+	defer cancel()
+
+	tx, err := s.store.db.BeginTx(ctx, nil)
+	if err != nil {
+		errorHandler(w, r, databaseError(err))
+		return false
+	}
+	defer tx.Rollback()
+
+	rejected, err := fn(ctx, tx)
+	if err != nil {
+		errorHandler(w, r, databaseError(err))
+		return false
+	}
+	if rejected != nil {
+		errorHandler(w, r, *rejected)
+		return false
+	}
+	if err := tx.Commit(); err != nil {
+		errorHandler(w, r, databaseError(err))
+		return false
+	}
+	s.store.Changed()
+	return true
+}
+
+// Request handler function for creating a track with POST /tracks
+// TrackId may be given, otherwise the next free id is used
+func (s *Server) createTrack(w http.ResponseWriter, r *http.Request) {
+	if !s.requireWritable(w, r) {
+		return
+	}
+	var track Track
+	raw, e := readTrackBody(r, true)
+	if e == nil {
+		e = applyTrackBody(raw, &track)
+	}
+	if e == nil {
+		e = validateTrack(&track)
+	}
+	if e != nil {
+		errorHandler(w, r, *e)
+		return
+	}
+
+	var created *Track
+	ok := s.inTransaction(w, r, func(ctx context.Context,
+		tx *sql.Tx) (*apiError, error) {
+		if track.TrackId.Valid {
+			var found int
+			err := tx.QueryRowContext(ctx,
+				"SELECT 1 FROM track WHERE TrackId = ?",
+				track.TrackId.Int64).Scan(&found)
+			if err == nil {
+				e := errTrackExists.withMessage("Track %d already exists",
+					track.TrackId.Int64)
+				return &e, nil
+			}
+			if err != sql.ErrNoRows {
+				return nil, err
+			}
+		}
+		if e, err := checkReferences(ctx, tx, &track); e != nil || err != nil {
+			return e, err
+		}
+
+		columns := []string{"TrackId"}
+		values := []interface{}{track.TrackId}
+		for _, f := range writableFields {
+			columns = append(columns, fieldColumn(f))
+			values = append(values, f.ref(&track))
+		}
+		result, err := tx.ExecContext(ctx, "INSERT INTO track ("+
+			strings.Join(columns, ", ")+") VALUES (?"+
+			strings.Repeat(", ?", len(columns)-1)+")", values...)
+		if err != nil {
+			return nil, err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		created, err = readTrack(ctx, tx, id)
+		return nil, err
+	})
+	if !ok {
+		return
+	}
+
+	logAt(levelInfo, "Created track "+strconv.FormatInt(created.TrackId.Int64, 10))
+	w.Header().Set("Location",
+		"/tracks/"+strconv.FormatInt(created.TrackId.Int64, 10))
+	writeJSON(w, r, http.StatusCreated, created)
+}
+
+// Request handler function for replacing a track with PUT or changing some
+// of its fields with PATCH
+// A PUT body must give every required field, fields it leaves out are
+// cleared. A TrackId in the body must be the id in the path
+func (s *Server) updateTrack(w http.ResponseWriter, r *http.Request) {
+	if !s.requireWritable(w, r) {
+		return
+	}
+	id, ok := pathID(r)
+	if !ok {
+		errorHandler(w, r, notFound("Track", r))
+		return
+	}
+	// The body is read before the transaction so a slow client does not
+	// hold the write lock
+	raw, e := readTrackBody(r, true)
+	if e != nil {
+		errorHandler(w, r, *e)
+		return
+	}
+
+	var updated *Track
+	ok = s.inTransaction(w, r, func(ctx context.Context,
+		tx *sql.Tx) (*apiError, error) {
+		stored, err := readTrack(ctx, tx, id)
+		if err == sql.ErrNoRows {
+			e := notFound("Track", r)
+			return &e, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		track := Track{TrackId: stored.TrackId}
+		if r.Method == http.MethodPatch {
+			track = *stored
+		}
+		if e := applyTrackBody(raw, &track); e != nil {
+			return e, nil
+		}
+		if track.TrackId != stored.TrackId {
+			e := invalidTrack("TrackId", "TrackId cannot be changed")
+			return &e, nil
+		}
+		if e := validateTrack(&track); e != nil {
+			return e, nil
+		}
+		if e, err := checkReferences(ctx, tx, &track); e != nil || err != nil {
+			return e, err
+		}
+
+		var set []string
+		var values []interface{}
+		for _, f := range writableFields {
+			set = append(set, fieldColumn(f)+" = ?")
+			values = append(values, f.ref(&track))
+		}
+		values = append(values, id)
+		_, err = tx.ExecContext(ctx, "UPDATE track SET "+
+			strings.Join(set, ", ")+" WHERE TrackId = ?", values...)
+		if err != nil {
+			return nil, err
+		}
+		updated, err = readTrack(ctx, tx, id)
+		return nil, err
+	})
+	if !ok {
+		return
+	}
+
+	logAt(levelInfo, "Updated track "+strconv.FormatInt(id, 10))
+	writeJSON(w, r, http.StatusOK, updated)
+}
+
+// Request handler function for deleting a track
+// Tracks that have been sold or are on a playlist cannot be deleted
+func (s *Server) deleteTrack(w http.ResponseWriter, r *http.Request) {
+	if !s.requireWritable(w, r) {
+		return
+	}
+	id, ok := pathID(r)
+	if !ok {
+		errorHandler(w, r, notFound("Track", r))
+		return
+	}
+
+	ok = s.inTransaction(w, r, func(ctx context.Context,
+		tx *sql.Tx) (*apiError, error) {
+		var invoiceLines, playlists int
+		err := tx.QueryRowContext(ctx, "SELECT "+
+			"(SELECT COUNT(*) FROM invoiceline WHERE TrackId = ?), "+
+			"(SELECT COUNT(*) FROM playlisttrack WHERE TrackId = ?)",
+			id, id).Scan(&invoiceLines, &playlists)
+		if err != nil {
+			return nil, err
+		}
+		if invoiceLines > 0 || playlists > 0 {
+			e := errTrackInUse.withMessage(
+				"Track %d is on %d invoice lines and %d playlists", id,
+				invoiceLines, playlists)
+			return &e, nil
+		}
+
+		result, err := tx.ExecContext(ctx,
+			"DELETE FROM track WHERE TrackId = ?", id)
+		if err != nil {
+			return nil, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			e := notFound("Track", r)
+			return &e, nil
+		}
+		return nil, nil
+	})
+	if !ok {
+		return
+	}
+
+	logAt(levelInfo, "Deleted track "+strconv.FormatInt(id, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNullUnmarshalJSON(t *testing.T) {
+	var track Track
+	err := json.Unmarshal([]byte(`{"Name":"Jump","Composer":null,`+
+		`"Milliseconds":1000,"UnitPrice":0.99}`), &track)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !track.Name.Valid || track.Name.String != "Jump" ||
+		track.Composer.Valid || !track.Milliseconds.Valid ||
+		track.Milliseconds.Int64 != 1000 || track.UnitPrice.Float64 != 0.99 ||
+		track.Bytes.Valid {
+		t.Errorf("wrong track: %+v", track)
+	}
+
+	// null clears a value that was set
+	if err := json.Unmarshal([]byte(`{"Name":null}`), &track); err != nil {
+		t.Fatal(err)
+	}
+	if track.Name.Valid {
+		t.Errorf("null did not clear Name: %+v", track.Name)
+	}
+	if err := json.Unmarshal([]byte(`{"Bytes":"many"}`), &track); err == nil {
+		t.Errorf("expected error for a string in an integer field")
+	}
+}
+
+// Function to send a request with a JSON body to a server
+func sendJSON(server *Server, method string, target string,
+	body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	server.routes().ServeHTTP(rec,
+		httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rec
+}
+
+func TestWriteTracks(t *testing.T) {
+	config := defaultConfig()
+	config.DBPath = copyDatabase(t)
+	config.ReadOnly = false
+	store, err := OpenStore(config.storeOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	server := NewServer(store, config)
+
+	// Create
+	rec := sendJSON(server, http.MethodPost, "/tracks", `{"Name":"Zyzzyva",`+
+		`"AlbumId":1,"MediaTypeId":1,"GenreId":1,"Milliseconds":1000,`+
+		`"UnitPrice":0.99}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create returned wrong status code: \n\ngot\n\n%v\n\nwant"+
+			"\n\n%v\n\n%s", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+	if location := rec.Header().Get("Location"); location != "/tracks/3504" {
+		t.Errorf("wrong location: \n\ngot\n\n%v\n\nwant\n\n%v", location,
+			"/tracks/3504")
+	}
+	var created map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created["Artist"] != "AC/DC" || created["Composer"] != nil {
+		t.Errorf("created track is wrong: %v", created)
+	}
+
+	// Patch changes only the fields given
+	rec = sendJSON(server, http.MethodPatch, "/tracks/3504",
+		`{"Composer":"Nobody","GenreId":null}`)
+	var patched map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &patched); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || patched["Name"] != "Zyzzyva" ||
+		patched["Composer"] != "Nobody" || patched["GenreId"] != nil {
+		t.Errorf("patch returned %v: %v", rec.Code, patched)
+	}
+
+	// Put replaces the track, clearing fields left out
+	rec = sendJSON(server, http.MethodPut, "/tracks/3504", `{"Name":"Z",`+
+		`"AlbumId":2,"MediaTypeId":2,"Milliseconds":5,"UnitPrice":1.99}`)
+	var replaced map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &replaced); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || replaced["Artist"] != "Accept" ||
+		replaced["Composer"] != nil {
+		t.Errorf("put returned %v: %v", rec.Code, replaced)
+	}
+
+	// Delete
+	rec = sendJSON(server, http.MethodDelete, "/tracks/3504", "")
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("delete returned wrong status code: \n\ngot\n\n%v\n\nwant"+
+			"\n\n%v", rec.Code, http.StatusNoContent)
+	}
+	rec = sendJSON(server, http.MethodGet, "/tracks/3504", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("deleted track returned wrong status code: \n\ngot\n\n%v"+
+			"\n\nwant\n\n%v", rec.Code, http.StatusNotFound)
+	}
+
+	// A track read with GET can be sent back, read-only fields are ignored
+	rec = sendJSON(server, http.MethodGet, "/tracks/1", "")
+	rec = sendJSON(server, http.MethodPut, "/tracks/1", rec.Body.String())
+	var resent map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resent); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || resent["Artist"] != "AC/DC" ||
+		resent["Album"] != "For Those About To Rock We Salute You" {
+		t.Errorf("put of a track read with GET returned %v: %v", rec.Code,
+			resent)
+	}
+	rec = sendJSON(server, http.MethodPatch, "/tracks/1",
+		`{"Artist":"Nobody","Album":"Nothing"}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(),
+		`"Artist":"AC/DC"`) {
+		t.Errorf("patch of read-only fields returned %v: %s", rec.Code,
+			rec.Body)
+	}
+
+	valid := `"AlbumId":1,"MediaTypeId":1,"Milliseconds":1,"UnitPrice":1`
+	bad := []struct {
+		method string
+		target string
+		body   string
+		status int
+		code   string
+		field  string
+	}{
+		{http.MethodPost, "/tracks", `{"Name":"A",` + valid + `,"TrackId":1}`,
+			http.StatusConflict, "track_exists", "TrackId"},
+		{http.MethodPost, "/tracks", `{"Name":"A",` + valid + `,"GenreId":99}`,
+			http.StatusUnprocessableEntity, "invalid_track", "GenreId"},
+		{http.MethodPost, "/tracks", `{"Name":" ",` + valid + `}`,
+			http.StatusUnprocessableEntity, "invalid_track", "Name"},
+		{http.MethodPost, "/tracks", `{"Name":"A",` + valid + `,"Bytes":-1}`,
+			http.StatusUnprocessableEntity, "invalid_track", "Bytes"},
+		{http.MethodPost, "/tracks", `{"Name":"A",` + valid + `,"Colour":1}`,
+			http.StatusUnprocessableEntity, "invalid_track", "Colour"},
+		{http.MethodPost, "/tracks", `{"Name":1}`,
+			http.StatusUnprocessableEntity, "invalid_track", "Name"},
+		{http.MethodPost, "/tracks", `{"Name":`, http.StatusBadRequest,
+			"invalid_body", ""},
+		{http.MethodPatch, "/tracks/1", `{"TrackId":2}`,
+			http.StatusUnprocessableEntity, "invalid_track", "TrackId"},
+		{http.MethodPatch, "/tracks/1", `{"Colour":1}`,
+			http.StatusUnprocessableEntity, "invalid_track", "Colour"},
+		{http.MethodPatch, "/tracks/1", `{"MediaTypeId":null}`,
+			http.StatusUnprocessableEntity, "invalid_track", "MediaTypeId"},
+		{http.MethodPut, "/tracks/99999", `{"Name":"A",` + valid + `}`,
+			http.StatusNotFound, "not_found", ""},
+		{http.MethodDelete, "/tracks/1", "", http.StatusConflict,
+			"track_in_use", ""},
+	}
+	for _, test := range bad {
+		rec := sendJSON(server, test.method, test.target, test.body)
+		if rec.Code != test.status {
+			t.Errorf("%s %s %s returned wrong status code: \n\ngot\n\n%v"+
+				"\n\nwant\n\n%v", test.method, test.target, test.body,
+				rec.Code, test.status)
+		}
+		ResponseErrorTest(rec, test.code, test.field, t)
+	}
+
+	// The shared test server is read-only
+	rec = sendJSON(testServer, http.MethodDelete, "/tracks/1", "")
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("read-only delete returned wrong status code: \n\ngot\n\n%v"+
+			"\n\nwant\n\n%v", rec.Code, http.StatusMethodNotAllowed)
+	}
+	ResponseErrorTest(rec, "read_only", "", t)
+}